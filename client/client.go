@@ -27,13 +27,9 @@ func main() {
 	// Initializing secret provider
 	// Pre requisites and behavior are mentioned in READ me.
 
-	arg := map[string]string{
-		sp.ProviderType: sp.VPC,
-	}
+	opts := []sp.Option{sp.WithProviderType(sp.VPC)}
 	// OR
-	//arg := map[string]string{
-	//	sp.SecretKey: "iam_api_key",
-	//}
+	//opts := []sp.Option{sp.WithSecretKey("iam_api_key")}
 
 	// For the client code to work, initialise a fake k8s client
 	/*
@@ -45,9 +41,9 @@ func main() {
 
 	// For real time scenarios, the following can be done
 	k8sClient, _ := k8s_utils.Getk8sClientSet()
-	secretprovider, err := sp.NewSecretProvider(&k8sClient, arg)
-	// OR, you may also provide a key which is expected to be present the k8s secret - ibm-cloud-credentials/storage-secret-store
-	// secretprovider, err := sp.NewSecretProvider(sp.VPC, "your-key")
+	secretprovider, err := sp.NewSecretProviderWithOptions(&k8sClient, opts...)
+	// OR, the deprecated map based arguments are still supported
+	// secretprovider, err := sp.NewSecretProvider(&k8sClient, map[string]string{sp.ProviderType: sp.VPC})
 
 	if err != nil {
 		fmt.Println("Error initializing provider")
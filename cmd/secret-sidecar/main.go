@@ -0,0 +1,79 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/IBM/secret-common-lib/pkg/sidecar"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func main() {
+	flag.Parse()
+	logger := setUpLogger()
+	defer func() {
+		_ = logger.Sync()
+	}()
+
+	// The sidecarEndpoint flag is registered by the secret provider package, the sidecar listens on the same socket the clients dial.
+	endpoint := flag.Lookup("sidecarEndpoint").Value.String()
+
+	conf, err := sidecar.ConfigFromEnv()
+	if err != nil {
+		logger.Fatal("Error reading configuration", zap.Error(err))
+	}
+
+	k8sClient, err := k8s_utils.Getk8sClientSet()
+	if err != nil {
+		logger.Fatal("Error fetching k8s client set", zap.Error(err))
+	}
+
+	server, err := sidecar.NewServer(logger, k8sClient, conf)
+	if err != nil {
+		logger.Fatal("Error initializing secret sidecar", zap.Error(err))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Info("Stopping secret sidecar", zap.String("signal", sig.String()))
+		server.Stop()
+	}()
+
+	if err := server.ListenAndServe(endpoint); err != nil {
+		logger.Fatal("Error serving secret provider", zap.Error(err))
+	}
+}
+
+// setUpLogger ...
+func setUpLogger() *zap.Logger {
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.TimeKey = "timestamp"
+	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(encoderCfg),
+		zapcore.Lock(os.Stdout),
+		zap.NewAtomicLevelAt(zap.InfoLevel),
+	), zap.AddCaller()).With(zap.String("name", "secret-sidecar"))
+}
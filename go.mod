@@ -3,21 +3,24 @@ module github.com/IBM/secret-common-lib
 go 1.23.10
 
 require (
+	github.com/BurntSushi/toml v1.0.0
+	github.com/IBM/go-sdk-core/v5 v5.17.4
 	github.com/IBM/secret-utils-lib v1.1.15
+	github.com/go-logr/logr v1.4.2
 	github.com/go-playground/validator/v10 v10.19.0
 	go.uber.org/zap v1.20.0
 	google.golang.org/grpc v1.47.0
+	k8s.io/api v0.32.8
+	k8s.io/apimachinery v0.32.8
+	k8s.io/client-go v0.32.8
 )
 
 require (
-	github.com/BurntSushi/toml v1.0.0 // indirect
-	github.com/IBM/go-sdk-core/v5 v5.17.4 // indirect
 	github.com/asaskevich/govalidator v0.0.0-20230301143203-a9d515a09cc2 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/emicklei/go-restful/v3 v3.11.0 // indirect
 	github.com/fxamacker/cbor/v2 v2.7.0 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
-	github.com/go-logr/logr v1.4.2 // indirect
 	github.com/go-openapi/errors v0.21.0 // indirect
 	github.com/go-openapi/jsonpointer v0.21.0 // indirect
 	github.com/go-openapi/jsonreference v0.20.2 // indirect
@@ -62,9 +65,6 @@ require (
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
-	k8s.io/api v0.32.8 // indirect
-	k8s.io/apimachinery v0.32.8 // indirect
-	k8s.io/client-go v0.32.8 // indirect
 	k8s.io/klog/v2 v2.130.1 // indirect
 	k8s.io/kube-openapi v0.0.0-20241105132330-32ad38e42d3f // indirect
 	k8s.io/utils v0.0.0-20241104100929-3ea5e8cea738 // indirect
@@ -0,0 +1,252 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/IBM/secret-utils-lib/pkg/config"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// cloudConfCM ...
+	cloudConfCM = "cloud-conf"
+	// cloudConfData ...
+	cloudConfData = "cloud-conf.json"
+	// clusterInfoCM ...
+	clusterInfoCM = "cluster-info"
+	// clusterConfigData ...
+	clusterConfigData = "cluster-config.json"
+	// cloudConfResourceGroupField ...
+	cloudConfResourceGroupField = "resource_group_id"
+	// cloudConfTokenExchangeURLField ...
+	cloudConfTokenExchangeURLField = "token_exchange_url"
+	// secretStoreResourceGroupPath ...
+	secretStoreResourceGroupPath = "VPC.g2_resource_group_id"
+)
+
+// The readers below are the same as k8s_utils.GetSecretData, k8s_utils.GetConfigMapData, config.GetCloudConf and
+// config.GetClusterInfo of secret-utils-lib, which do not accept a context. They are used wherever the secret provider
+// reads the config, so that the reads honour the cancellation of ctx.
+
+// getSecretData reads the given key from the k8s secret, honouring the cancellation of ctx.
+func getSecretData(ctx context.Context, kc k8s_utils.KubernetesClient, secretName, secretKey string) (string, error) {
+	secret, err := kc.Clientset.CoreV1().Secrets(kc.Namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return secretData(secret, secretName, secretKey)
+}
+
+// secretData returns the given key of the secret.
+func secretData(secret *v1.Secret, secretName, secretKey string) (string, error) {
+	if secret.Data == nil {
+		return "", utils.Error{Description: fmt.Sprintf(utils.ErrEmptyDataInSecret, secretName)}
+	}
+
+	byteData, ok := secret.Data[secretKey]
+	if !ok {
+		return "", utils.Error{Description: fmt.Sprintf(utils.ErrExpectedDataNotFound, secretKey, secretName)}
+	}
+
+	return strings.TrimSuffix(string(byteData), "\n"), nil
+}
+
+// getConfigMapData reads the given key from the k8s config map, honouring the cancellation of ctx.
+func getConfigMapData(ctx context.Context, kc k8s_utils.KubernetesClient, configMapName, dataName string) (string, error) {
+	cm, err := kc.Clientset.CoreV1().ConfigMaps(kc.Namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return configMapData(cm, configMapName, dataName)
+}
+
+// configMapData returns the given key of the config map.
+func configMapData(cm *v1.ConfigMap, configMapName, dataName string) (string, error) {
+	data, ok := cm.Data[dataName]
+	if !ok {
+		return "", utils.Error{Description: fmt.Sprintf(utils.ErrEmptyConfigMapData, dataName, configMapName)}
+	}
+
+	return data, nil
+}
+
+// getCloudConf reads and parses the cloud-conf config map.
+func getCloudConf(ctx context.Context, kc k8s_utils.KubernetesClient) (config.CloudConf, error) {
+	var cloudConf config.CloudConf
+	data, err := getConfigMapData(ctx, kc, cloudConfCM, cloudConfData)
+	if err != nil {
+		return cloudConf, err
+	}
+
+	err = json.Unmarshal([]byte(data), &cloudConf)
+	return cloudConf, err
+}
+
+// getClusterInfo reads and parses the cluster-info config map.
+func getClusterInfo(ctx context.Context, logger *zap.Logger, kc k8s_utils.KubernetesClient) (config.ClusterConfig, error) {
+	var cc config.ClusterConfig
+	data, err := getConfigMapData(ctx, kc, clusterInfoCM, clusterConfigData)
+	if err != nil {
+		logger.Error("Error fetching cluster info", zap.Error(err))
+		return cc, err
+	}
+
+	if err = json.Unmarshal([]byte(data), &cc); err != nil {
+		logger.Error("Error fetching cluster-info configmap", zap.Error(err))
+		return cc, utils.Error{Description: utils.ErrFetchingClusterConfig, BackendError: err.Error()}
+	}
+	return cc, nil
+}
+
+// getSecretStoreConfig reads and parses slclient.toml from storage-secret-store.
+func getSecretStoreConfig(ctx context.Context, logger *zap.Logger, kc k8s_utils.KubernetesClient) (*config.Config, error) {
+	data, err := getSecretData(ctx, kc, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.ParseConfig(logger, data)
+}
+
+// getSecretStore reads slclient.toml from storage-secret-store once, and returns it parsed into the config as well as
+// its tables and fields.
+func getSecretStore(ctx context.Context, logger *zap.Logger, kc k8s_utils.KubernetesClient) (*config.Config, map[string]interface{}, error) {
+	data, err := getSecretData(ctx, kc, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conf, err := config.ParseConfig(logger, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, err := parseSecretStoreFields(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conf, fields, nil
+}
+
+// getCloudConfFields reads cloud-conf.json from the cloud-conf config map and parses it into its fields.
+func getCloudConfFields(ctx context.Context, kc k8s_utils.KubernetesClient) (map[string]interface{}, error) {
+	data, err := getConfigMapData(ctx, kc, cloudConfCM, cloudConfData)
+	if err != nil {
+		return nil, err
+	}
+	return parseCloudConfFields(data)
+}
+
+// parseCloudConfFields ...
+func parseCloudConfFields(data string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := json.Unmarshal([]byte(data), &fields)
+	return fields, err
+}
+
+// getSecretStoreFields reads slclient.toml from storage-secret-store and parses it into its tables and fields.
+func getSecretStoreFields(ctx context.Context, kc k8s_utils.KubernetesClient) (map[string]interface{}, error) {
+	data, err := getSecretData(ctx, kc, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
+	if err != nil {
+		return nil, err
+	}
+	return parseSecretStoreFields(data)
+}
+
+// parseSecretStoreFields ...
+func parseSecretStoreFields(data string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	_, err := toml.Decode(data, &fields)
+	return fields, err
+}
+
+// lookupField returns the string at the given path of the parsed config, the tables in the path are separated by dots.
+// The keys are matched ignoring case, same as config.ParseConfig, so that [vpc] matches VPC. An empty string is returned
+// if the path is not present or does not hold a string.
+func lookupField(fields map[string]interface{}, path string) string {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		table, ok := lookupKey(fields, key).(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		fields = table
+	}
+
+	value, _ := lookupKey(fields, keys[len(keys)-1]).(string)
+	return value
+}
+
+// lookupKey returns the value of the given key, else the value of a key which differs only in case.
+func lookupKey(fields map[string]interface{}, key string) interface{} {
+	if value, ok := fields[key]; ok {
+		return value
+	}
+	for k, value := range fields {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+	return nil
+}
+
+// readConfig reads the region, resource group ID and token exchange URL provided in cloud-conf, else the resource group ID
+// provided in storage-secret-store. The ones which are not provided are empty.
+func readConfig(ctx context.Context, reader configReader) (region, resourceGroupID, tokenExchangeURL string, err error) {
+	fields, err := reader.cloudConfFields(ctx)
+	if err == nil {
+		return lookupField(fields, cloudConfRegionField), lookupField(fields, cloudConfResourceGroupField), lookupField(fields, cloudConfTokenExchangeURLField), nil
+	}
+
+	fields, err = reader.secretStoreFields(ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+	return "", lookupField(fields, secretStoreResourceGroupPath), "", nil
+}
+
+// getTokenExchangeURL returns the token exchange URL provided in cloud-conf, else the one framed using storage-secret-store
+// for the given provider type, else the one framed using the cluster info. It returns whether the URL was provided.
+func getTokenExchangeURL(ctx context.Context, logger *zap.Logger, kc k8s_utils.KubernetesClient, providerType string) (string, bool) {
+	if cloudConf, err := getCloudConf(ctx, kc); err == nil && cloudConf.TokenExchangeURL != "" {
+		logger.Info("Using the token exchange URL provided in cloud-conf")
+		return cloudConf.TokenExchangeURL, true
+	}
+
+	cc, _ := getClusterInfo(ctx, logger, kc)
+	if providerType == "" {
+		providerType = utils.VPC
+	}
+	if conf, err := getSecretStoreConfig(ctx, logger, kc); err == nil {
+		if tokenExchangeURL, providedTokenExchangeURL, err := config.GetTokenExchangeURLfromStorageSecretStore(cc, *conf, providerType); err == nil {
+			logger.Info("Framed token exchange URL using storage-secret-store")
+			return tokenExchangeURL, providedTokenExchangeURL
+		}
+	}
+
+	logger.Info("Framed token exhange URL from cluster info")
+	return config.FrameTokenExchangeURLFromClusterInfo(cc, logger)
+}
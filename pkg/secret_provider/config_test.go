@@ -0,0 +1,122 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLookupField(t *testing.T) {
+	fields := map[string]interface{}{
+		"VPC":     map[string]interface{}{"g2_riaas_endpoint_url": "https://us-south.iaas.cloud.ibm.com", "encryption": false},
+		"bluemix": map[string]interface{}{"IAM_URL": "https://iam.cloud.ibm.com"},
+		"region":  "us-south",
+		"Region":  "eu-de",
+	}
+
+	testCases := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{name: "field", path: "region", expected: "us-south"},
+		{name: "exact match is preferred", path: "Region", expected: "eu-de"},
+		{name: "table field", path: "VPC.g2_riaas_endpoint_url", expected: "https://us-south.iaas.cloud.ibm.com"},
+		{name: "table differing in case", path: "vpc.g2_riaas_endpoint_url", expected: "https://us-south.iaas.cloud.ibm.com"},
+		{name: "lower case table", path: "Bluemix.iam_url", expected: "https://iam.cloud.ibm.com"},
+		{name: "missing table", path: "Softlayer.softlayer_token_exchange_endpoint_url"},
+		{name: "missing field", path: "VPC.g2_resource_group_id"},
+		{name: "not a string", path: "VPC.encryption"},
+		{name: "not a table", path: "region.name"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if value := lookupField(fields, tc.path); value != tc.expected {
+				t.Errorf("Expected %q at %s, got %q", tc.expected, tc.path, value)
+			}
+		})
+	}
+}
+
+func TestInitEndpointsUsingStorageSecretStore(t *testing.T) {
+	secretStore := `[vpc]
+  g2_token_exchange_endpoint_url = "https://iam.cloud.ibm.com"
+  g2_riaas_endpoint_url = "https://eu-de.iaas.cloud.ibm.com"
+  g2_riaas_endpoint_private_url = "https://eu-de.private.iaas.cloud.ibm.com"
+  g2_resource_group_id = "resource-group-id"
+  provider_type = "g2"
+`
+
+	testCases := []struct {
+		name    string
+		managed bool
+	}{
+		{name: "unmanaged"},
+		{name: "managed", managed: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kc, err := k8s_utils.FakeGetk8sClientSet()
+			if err != nil {
+				t.Fatalf("Unable to create fake k8s client: %v", err)
+			}
+			setAPIKey(t, kc, "api-key", nil)
+			setSecret(t, kc, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE, secretStore, nil)
+			clientset := kc.Clientset.(*fake.Clientset)
+			clientset.ClearActions()
+
+			var provider interface {
+				GetRIAASEndpoint(readConfig bool) (string, error)
+				GetResourceGroupID() string
+			}
+			if tc.managed {
+				provider, err = initManagedSecretProvider(context.Background(), &kc, zap.NewNop(), newTestProviderOptions(t))
+			} else {
+				provider, err = newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t))
+			}
+			if err != nil {
+				t.Fatalf("Unable to initialise secret provider: %v", err)
+			}
+
+			// The lower case tables match, same as config.ParseConfig
+			if endpoint, err := provider.GetRIAASEndpoint(false); err != nil || endpoint != "https://eu-de.iaas.cloud.ibm.com" {
+				t.Errorf("Expected the riaas endpoint of storage-secret-store, got %q, %v", endpoint, err)
+			}
+			if resourceGroupID := provider.GetResourceGroupID(); resourceGroupID != "resource-group-id" {
+				t.Errorf("Expected the resource group ID of storage-secret-store, got %q", resourceGroupID)
+			}
+
+			reads := 0
+			for _, action := range clientset.Actions() {
+				if get, ok := action.(interface{ GetName() string }); ok && action.GetVerb() == "get" && action.GetResource().Resource == "secrets" && get.GetName() == utils.STORAGE_SECRET_STORE_SECRET {
+					reads++
+				}
+			}
+			if reads != 1 {
+				t.Errorf("Expected storage-secret-store to be read once, read %d times", reads)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// configWatcher watches the cloud-conf config map and storage-secret-store, using an informer for each. The endpoints
+// are read from the cache of the informers, instead of the API server.
+type configWatcher struct {
+	stop      chan struct{}
+	factories []informers.SharedInformerFactory
+	hasSynced []cache.InformerSynced
+	closeOnce sync.Once
+
+	// reloadMutex serialises reading the endpoints on the events of either informer
+	reloadMutex sync.Mutex
+
+	configMaps listersv1.ConfigMapNamespaceLister
+	secrets    listersv1.SecretNamespaceLister
+}
+
+// synced returns true once the caches of the informers hold the config.
+func (cw *configWatcher) synced() bool {
+	for _, hasSynced := range cw.hasSynced {
+		if !hasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// cloudConfFields reads cloud-conf.json from the cached cloud-conf config map.
+func (cw *configWatcher) cloudConfFields(ctx context.Context) (map[string]interface{}, error) {
+	cm, err := cw.configMaps.Get(cloudConfCM)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := configMapData(cm, cloudConfCM, cloudConfData)
+	if err != nil {
+		return nil, err
+	}
+	return parseCloudConfFields(data)
+}
+
+// secretStoreFields reads slclient.toml from the cached storage-secret-store.
+func (cw *configWatcher) secretStoreFields(ctx context.Context) (map[string]interface{}, error) {
+	secret, err := cw.secrets.Get(utils.STORAGE_SECRET_STORE_SECRET)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := secretData(secret, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
+	if err != nil {
+		return nil, err
+	}
+	return parseSecretStoreFields(data)
+}
+
+// startWatcher starts watching the config, the endpoints are read again whenever cloud-conf or storage-secret-store is
+// created, updated or deleted, and publish is called for each of them. reloadConfig is then called to read the rest of
+// the config held by the secret provider.
+func (er *endpointRegistry) startWatcher(k8sClient k8s_utils.KubernetesClient, logger *zap.Logger, publish func(endpoint, previousValue, value string), reloadConfig func(reader configReader)) {
+	cw := &configWatcher{stop: make(chan struct{})}
+	reload := func(name, event string) {
+		logger.Info("Config changed, reading endpoints", zap.String("name", name), zap.String("event", event))
+		cw.reloadMutex.Lock()
+		defer cw.reloadMutex.Unlock()
+		er.reloadEndpoints(cw, logger, publish)
+		reloadConfig(cw)
+	}
+
+	for _, name := range []string{cloudConfCM, utils.STORAGE_SECRET_STORE_SECRET} {
+		factory := informers.NewSharedInformerFactoryWithOptions(k8sClient.Clientset, 0,
+			informers.WithNamespace(k8sClient.Namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+			}))
+
+		var informer cache.SharedIndexInformer
+		if name == cloudConfCM {
+			informer = factory.Core().V1().ConfigMaps().Informer()
+			cw.configMaps = factory.Core().V1().ConfigMaps().Lister().ConfigMaps(k8sClient.Namespace)
+		} else {
+			informer = factory.Core().V1().Secrets().Informer()
+			cw.secrets = factory.Core().V1().Secrets().Lister().Secrets(k8sClient.Namespace)
+		}
+
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				// The config present at the start was read while initialising the secret provider
+				if !isInInitialList {
+					reload(name, "created")
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if !reflect.DeepEqual(configData(oldObj), configData(newObj)) {
+					reload(name, "updated")
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				reload(name, "deleted")
+			},
+		})
+		if err != nil {
+			// The endpoints are read from the API server, since the cache of the watcher would be incomplete
+			logger.Error("Unable to watch config, the config watcher is not started", zap.String("name", name), zap.Error(err))
+			cw.close()
+			return
+		}
+
+		factory.Start(cw.stop)
+		cw.factories = append(cw.factories, factory)
+		cw.hasSynced = append(cw.hasSynced, informer.HasSynced)
+	}
+
+	er.mutex.Lock()
+	er.watcher = cw
+	er.mutex.Unlock()
+	logger.Info("Started config watcher")
+
+	// The config may have changed after it was read while initialising the secret provider, and before the initial list
+	// of the informers, whose events are not handled. Hence the endpoints are read again once the caches are synced.
+	go func() {
+		if cache.WaitForCacheSync(cw.stop, cw.hasSynced...) {
+			reload(cloudConfCM, "synced")
+		}
+	}()
+}
+
+// stopWatcher stops the config watcher, if it is running.
+func (er *endpointRegistry) stopWatcher() {
+	er.mutex.Lock()
+	cw := er.watcher
+	er.watcher = nil
+	er.mutex.Unlock()
+	if cw != nil {
+		cw.close()
+	}
+}
+
+// close stops the informers of the watcher.
+func (cw *configWatcher) close() {
+	cw.closeOnce.Do(func() { close(cw.stop) })
+	for _, factory := range cw.factories {
+		factory.Shutdown()
+	}
+}
+
+// reloadEndpoints reads all the endpoints from the cache of the watcher, cw.reloadMutex must be held. If an endpoint
+// cannot be read, the last one read is kept. The endpoints are validated concurrently, and the validation is cancelled
+// once the watcher is stopped, in which case none of the endpoints is replaced.
+func (er *endpointRegistry) reloadEndpoints(cw *configWatcher, logger *zap.Logger, publish func(endpoint, previousValue, value string)) {
+	ctx, cancel := cw.context()
+	defer cancel()
+
+	var definitions []EndpointDefinition
+	for _, name := range er.names() {
+		definitions = append(definitions, er.definitions[name])
+	}
+	reads := er.readEndpoints(ctx, definitions, cw, logger)
+	if ctx.Err() != nil {
+		logger.Info("Config watcher stopped, the endpoints are not reloaded")
+		return
+	}
+
+	for _, definition := range definitions {
+		read := reads[definition.Name]
+		if read.err != nil {
+			logger.Warn(fmt.Sprintf("Unable to read %s endpoint, keeping the previous one", definition.Name), zap.Error(read.err))
+			continue
+		}
+		publish(definition.Name, er.set(definition, read.value, read.source), read.value)
+	}
+}
+
+// context returns a context which is cancelled once the watcher is stopped.
+func (cw *configWatcher) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-cw.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// configData returns the data of the config map or the secret.
+func configData(obj interface{}) interface{} {
+	switch config := obj.(type) {
+	case *v1.ConfigMap:
+		return config.Data
+	case *v1.Secret:
+		return config.Data
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// blockingProbe is an endpoint probe which waits for the given delay, or until its context is done.
+type blockingProbe struct {
+	mutex     sync.Mutex
+	delay     time.Duration
+	active    int
+	maxActive int
+	started   chan struct{}
+}
+
+// probe ...
+func (bp *blockingProbe) probe(ctx context.Context, endpoint string) error {
+	bp.mutex.Lock()
+	delay := bp.delay
+	bp.active++
+	bp.maxActive = max(bp.maxActive, bp.active)
+	started := bp.started
+	bp.started = nil
+	bp.mutex.Unlock()
+	if started != nil {
+		close(started)
+	}
+
+	defer func() {
+		bp.mutex.Lock()
+		bp.active--
+		bp.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// set sets the delay of the probes, and resets the number of probes seen at once. The returned channel is closed once
+// the next probe starts.
+func (bp *blockingProbe) set(delay time.Duration) <-chan struct{} {
+	bp.mutex.Lock()
+	defer bp.mutex.Unlock()
+	bp.delay = delay
+	bp.maxActive = 0
+	bp.started = make(chan struct{})
+	return bp.started
+}
+
+// newTestConfigWatcher initialises an unmanaged secret provider with the config watcher, whose endpoints are validated
+// using the given probe, and returns it once the cache of the watcher holds the config.
+func newTestConfigWatcher(t *testing.T, bp *blockingProbe) (*UnmanagedSecretProvider, *configWatcher) {
+	t.Helper()
+	kc := newFakeK8sClient(t, "https://iam.cloud.ibm.com")
+	usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t, WithConfigWatcher(), WithEndpointValidation(bp.probe)))
+	if err != nil {
+		t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+	}
+	t.Cleanup(func() { _ = usp.Close() })
+
+	usp.endpoints.mutex.Lock()
+	cw := usp.endpoints.watcher
+	usp.endpoints.mutex.Unlock()
+	if cw == nil {
+		t.Fatal("Expected the config watcher to be running")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !cw.synced() {
+		if time.Now().After(deadline) {
+			t.Fatal("Config watcher did not sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return usp, cw
+}
+
+func TestConfigWatcherValidatesConcurrently(t *testing.T) {
+	bp := &blockingProbe{}
+	usp, cw := newTestConfigWatcher(t, bp)
+
+	delay := 200 * time.Millisecond
+	bp.set(delay)
+	start := time.Now()
+	cw.reloadMutex.Lock()
+	usp.endpoints.reloadEndpoints(cw, zap.NewNop(), func(endpoint, previousValue, value string) {})
+	cw.reloadMutex.Unlock()
+
+	// The four endpoints in cloud-conf are probed at once, instead of one after another
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Errorf("Expected the endpoints to be validated concurrently, took %v", elapsed)
+	}
+	bp.mutex.Lock()
+	maxActive := bp.maxActive
+	bp.mutex.Unlock()
+	if maxActive < 2 {
+		t.Errorf("Expected the probes to run concurrently, at most %d ran at once", maxActive)
+	}
+}
+
+func TestConfigWatcherStopCancelsValidation(t *testing.T) {
+	bp := &blockingProbe{}
+	usp, cw := newTestConfigWatcher(t, bp)
+	before := usp.endpoints.describe()
+
+	started := bp.set(time.Minute)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cw.reloadMutex.Lock()
+		defer cw.reloadMutex.Unlock()
+		usp.endpoints.reloadEndpoints(cw, zap.NewNop(), func(endpoint, previousValue, value string) {
+			t.Errorf("Expected %s endpoint not to be replaced once the watcher is stopped", endpoint)
+		})
+	}()
+
+	<-started
+	_ = usp.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the validation to be cancelled once the watcher is stopped")
+	}
+
+	after := usp.endpoints.describe()
+	for i := range before {
+		if before[i].Value != after[i].Value {
+			t.Errorf("Expected %s endpoint %q to be kept, got %q", before[i].Name, before[i].Value, after[i].Value)
+		}
+	}
+}
+
+func TestConfigWatcherReadsConfigChangedBeforeSync(t *testing.T) {
+	kc := newFakeK8sClient(t, "https://iam.cloud.ibm.com")
+	clientset := kc.Clientset.(*fake.Clientset)
+
+	// cloud-conf is changed after the secret provider read it, and before the initial list of the watcher
+	var once sync.Once
+	clientset.PrependReactor("list", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		once.Do(func() {
+			cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: cloudConfCM, Namespace: kc.Namespace}, Data: map[string]string{
+				cloudConfData: `{"region": "eu-de", "riaas_endpoint": "https://eu-de.iaas.cloud.ibm.com"}`,
+			}}
+			if err := clientset.Tracker().Update(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, cm, kc.Namespace); err != nil {
+				t.Errorf("Unable to update cloud-conf: %v", err)
+			}
+		})
+		return false, nil, nil
+	})
+
+	usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t, WithConfigWatcher()))
+	if err != nil {
+		t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+	}
+	t.Cleanup(func() { _ = usp.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for usp.GetRegion() != "eu-de" || usp.endpoints.get(localutils.RIAAS).value != "https://eu-de.iaas.cloud.ibm.com" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the config changed before the watcher synced to be read, region is %q", usp.GetRegion())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,237 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	auth "github.com/IBM/secret-utils-lib/pkg/authenticator"
+	"github.com/IBM/secret-utils-lib/pkg/token"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// vaultTokenPathEnv is the environment variable which can hold the compute resource token file of the pod.
+	vaultTokenPathEnv = "IBMC_VAULT_TOKEN_PATH"
+
+	// maxRetryAttempt is the number of attempts to fetch the token when IAM times out, same as the authenticators of secret-utils-lib.
+	maxRetryAttempt = 9
+
+	// initialRetryGap is the time after which the token is fetched again when IAM times out, it is doubled on every attempt.
+	initialRetryGap = 2 * time.Second
+
+	// maxRetryGap ...
+	maxRetryGap = 60 * time.Second
+)
+
+// CredentialType ...
+type CredentialType string
+
+const (
+	// APIKey is an IBM Cloud api key.
+	APIKey CredentialType = "api-key"
+
+	// TrustedProfile is a trusted profile, whose token is fetched using the compute resource token of the pod.
+	TrustedProfile CredentialType = "trusted-profile"
+)
+
+// Credential describes the credential whose token is fetched using GetIAMTokenForCredential.
+type Credential struct {
+	Type CredentialType
+
+	// APIKey is required for APIKey.
+	APIKey string
+
+	// Either ProfileID or ProfileName is required for TrustedProfile.
+	ProfileID   string
+	ProfileName string
+
+	// CRTokenFile is the compute resource token file, for TrustedProfile. If it is not provided, the file given in
+	// IBMC_VAULT_TOKEN_PATH is used, else /var/run/secrets/tokens/vault-token or /var/run/secrets/tokens/sa-token.
+	CRTokenFile string
+}
+
+// CredentialTokenProvider is implemented by the managed, unmanaged and failover secret providers.
+type CredentialTokenProvider interface {
+	// GetIAMTokenForCredential returns the token of the given credential, irrespective of the auth type of the default secret.
+	// The token is fetched by the application in both managed and unmanaged modes, and cached along with those of the
+	// secrets provided in GetIAMToken.
+	GetIAMTokenForCredential(ctx context.Context, credential Credential, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error)
+}
+
+// validate ...
+func (c Credential) validate() error {
+	switch c.Type {
+	case APIKey:
+		if c.APIKey == "" {
+			return utils.Error{Description: localutils.ErrInvalidCredential, BackendError: "api key is empty"}
+		}
+	case TrustedProfile:
+		if c.ProfileID == "" && c.ProfileName == "" {
+			return utils.Error{Description: localutils.ErrInvalidCredential, BackendError: "profile id and profile name are empty"}
+		}
+	default:
+		return utils.Error{Description: localutils.ErrInvalidCredential, BackendError: "unknown credential type " + string(c.Type)}
+	}
+	return nil
+}
+
+// cacheKey returns the key under which the token of the credential is cached, the fields are separated by a newline,
+// which cannot be part of any of them.
+func (c Credential) cacheKey() string {
+	return hashSecret(strings.Join([]string{string(c.Type), c.APIKey, c.ProfileID, c.ProfileName, c.CRTokenFile}, "\n"))
+}
+
+// newAuthenticator returns the authenticator of the credential, using the given token exchange URL.
+func (c Credential) newAuthenticator(logger *zap.Logger, tokenExchangeURL string, providedTokenExchangeURL bool) tokenFetcher {
+	if c.Type == APIKey {
+		authenticator := auth.NewIamAuthenticator(c.APIKey, logger)
+		authenticator.SetURL(tokenExchangeURL, providedTokenExchangeURL)
+		return authenticator
+	}
+
+	crTokenFile := c.CRTokenFile
+	if crTokenFile == "" {
+		crTokenFile = os.Getenv(vaultTokenPathEnv)
+	}
+	return &containerAuthenticator{
+		logger:                   logger,
+		profileID:                c.ProfileID,
+		profileName:              c.ProfileName,
+		crTokenFile:              crTokenFile,
+		tokenExchangeURL:         tokenExchangeURL,
+		providedTokenExchangeURL: providedTokenExchangeURL,
+	}
+}
+
+// containerAuthenticator fetches the token of a trusted profile given by id or name, unlike the compute identity
+// authenticator of secret-utils-lib, which only accepts the profile id.
+type containerAuthenticator struct {
+	logger                   *zap.Logger
+	profileID                string
+	profileName              string
+	crTokenFile              string
+	tokenExchangeURL         string
+	providedTokenExchangeURL bool
+
+	// client is used to request the token, the default client of go-sdk-core is used if it is nil.
+	client *http.Client
+}
+
+// GetToken ...
+func (ca *containerAuthenticator) GetToken(freshTokenRequired bool) (string, uint64, error) {
+	return ca.GetTokenContext(context.Background(), freshTokenRequired)
+}
+
+// GetTokenContext fetches a fresh token, the token is cached by the secret provider. The request is retried while IAM
+// times out, same as the authenticators of secret-utils-lib, until ctx is done. If the private IAM URL times out and
+// the token exchange URL was not provided, the public IAM URL is tried.
+func (ca *containerAuthenticator) GetTokenContext(ctx context.Context, freshTokenRequired bool) (string, uint64, error) {
+	tokenResponse, err := ca.requestTokenWithRetry(ctx, ca.tokenExchangeURL)
+	if err != nil && ctx.Err() == nil && !ca.providedTokenExchangeURL && isTimeout(err) {
+		if publicURL := publicIAMURL(ca.tokenExchangeURL); publicURL != ca.tokenExchangeURL {
+			ca.logger.Info("Retrying to fetch IAM token using public IAM URL")
+			tokenResponse, err = ca.requestTokenWithRetry(ctx, publicURL)
+		}
+	}
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return "", 0, ctxErr
+	}
+	if err != nil {
+		return "", 0, utils.Error{Description: "Error fetching iam token using trusted profile", BackendError: err.Error()}
+	}
+	if tokenResponse == nil {
+		return "", 0, utils.Error{Description: utils.ErrEmptyTokenResponse}
+	}
+
+	tokenlifetime, err := token.CheckTokenLifeTime(tokenResponse.AccessToken)
+	if err != nil {
+		return "", 0, utils.Error{Description: "Error fetching token lifetime", BackendError: err.Error()}
+	}
+	ca.logger.Info("Fetched fresh iam token using trusted profile", zap.Uint64("token-life-time-in-seconds", tokenlifetime))
+	return tokenResponse.AccessToken, tokenlifetime, nil
+}
+
+// requestTokenWithRetry requests the token, retrying up to maxRetryAttempt times while IAM times out. The time between
+// the attempts starts at 2s and is doubled every attempt, up to 60s. The retries stop as soon as ctx is done, the
+// request in progress is not cancelled, since the authenticator of go-sdk-core does not accept a context.
+func (ca *containerAuthenticator) requestTokenWithRetry(ctx context.Context, tokenExchangeURL string) (*core.IamTokenServerResponse, error) {
+	retryGap := initialRetryGap
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tokenResponse, err := ca.requestToken(tokenExchangeURL)
+		if err == nil || !isTimeout(err) || attempt == maxRetryAttempt {
+			return tokenResponse, err
+		}
+
+		ca.logger.Error("Error fetching fresh token", zap.Error(err), zap.Int("AttemptNo", attempt))
+		timer := time.NewTimer(retryGap)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		retryGap = min(2*retryGap, maxRetryGap)
+	}
+}
+
+// isTimeout returns true if the request to IAM timed out. The error of the http client is held by the authentication
+// error of go-sdk-core, without being wrapped.
+func isTimeout(err error) bool {
+	var authErr *core.AuthenticationError
+	if errors.As(err, &authErr) && authErr.Err != nil {
+		err = authErr.Err
+	}
+
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// requestToken ...
+func (ca *containerAuthenticator) requestToken(tokenExchangeURL string) (*core.IamTokenServerResponse, error) {
+	authenticator := &core.ContainerAuthenticator{
+		IAMProfileID:    ca.profileID,
+		IAMProfileName:  ca.profileName,
+		CRTokenFilename: ca.crTokenFile,
+		URL:             tokenExchangeURL,
+		Client:          ca.client,
+	}
+	return authenticator.RequestToken()
+}
+
+// publicIAMURL returns the public IAM URL corresponding to the given private one, else the given URL.
+func publicIAMURL(url string) string {
+	switch {
+	case strings.Contains(url, utils.ProdPrivateIAMURL):
+		return strings.Replace(url, utils.ProdPrivateIAMURL, utils.ProdPublicIAMURL, 1)
+	case strings.Contains(url, utils.StagePrivateIAMURL):
+		return strings.Replace(url, utils.StagePrivateIAMURL, utils.StagePublicIAMURL, 1)
+	}
+	return url
+}
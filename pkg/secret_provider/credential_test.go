@@ -0,0 +1,363 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeIAMServer serves the IAM token exchange, returning a new token with the given lifetime on every request.
+type fakeIAMServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	lifetime time.Duration
+	status   int
+	latency  time.Duration
+	forms    []map[string]string
+}
+
+// newFakeIAMServer starts a fake IAM server, which is closed along with the test.
+func newFakeIAMServer(t *testing.T) *fakeIAMServer {
+	t.Helper()
+	s := &fakeIAMServer{lifetime: time.Hour, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveToken))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// serveToken ...
+func (s *fakeIAMServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	s.mutex.Lock()
+	form := make(map[string]string)
+	for key := range r.PostForm {
+		form[key] = r.PostForm.Get(key)
+	}
+	s.forms = append(s.forms, form)
+	lifetime, status, latency, count := s.lifetime, s.status, s.latency, len(s.forms)
+	s.mutex.Unlock()
+
+	time.Sleep(latency)
+	if r.URL.Path != "/identity/token" || status != http.StatusOK {
+		if status == http.StatusOK {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"errorCode": "BXNIM0415E", "errorMessage": "Provided API key could not be found"}`))
+		return
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": fakeIAMToken(now, lifetime, count),
+		"token_type":   "Bearer",
+		"expires_in":   int64(lifetime / time.Second),
+		"expiration":   now.Add(lifetime).Unix(),
+	})
+}
+
+// setResponse sets the lifetime of the tokens, and the status and latency of the responses.
+func (s *fakeIAMServer) setResponse(lifetime time.Duration, status int, latency time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lifetime, s.status, s.latency = lifetime, status, latency
+}
+
+// requests returns the forms of the requests received so far, in order.
+func (s *fakeIAMServer) requests() []map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]map[string]string(nil), s.forms...)
+}
+
+// fakeIAMToken returns an unsigned jwt with the given lifetime, the count makes the tokens of the same second differ.
+func fakeIAMToken(now time.Time, lifetime time.Duration, count int) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iat":%d,"exp":%d,"jti":"%d"}`, now.Unix(), now.Add(lifetime).Unix(), count)))
+	return header + "." + claims + ".c2ln"
+}
+
+// newFakeK8sClient returns a fake k8s client holding ibm-cloud-credentials, and cloud-conf with the given token exchange URL.
+func newFakeK8sClient(t *testing.T, tokenExchangeURL string) k8s_utils.KubernetesClient {
+	t.Helper()
+	kc, err := k8s_utils.FakeGetk8sClientSet()
+	if err != nil {
+		t.Fatalf("Unable to create fake k8s client: %v", err)
+	}
+	if err := k8s_utils.FakeCreateSecret(kc, "iam", "../../test-fixtures/secrets/ibm-cloud-credentials/iam-cloud-provider.env"); err != nil {
+		t.Fatalf("Unable to create ibm-cloud-credentials: %v", err)
+	}
+
+	cloudConf := fmt.Sprintf(`{"region": "us-south", "riaas_endpoint": "https://us-south.iaas.cloud.ibm.com", "riaas_private_endpoint": "https://us-south.private.iaas.cloud.ibm.com", "containers_api_route": "https://us-south.containers.cloud.ibm.com", "containers_api_route_private": "https://private.us-south.containers.cloud.ibm.com", "token_exchange_url": %q}`, tokenExchangeURL)
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cloud-conf", Namespace: kc.Namespace}, Data: map[string]string{"cloud-conf.json": cloudConf}}
+	if _, err := kc.Clientset.CoreV1().ConfigMaps(kc.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create cloud-conf: %v", err)
+	}
+	return kc
+}
+
+// newTestProviderOptions ...
+func newTestProviderOptions(t *testing.T, opts ...Option) *providerOptions {
+	t.Helper()
+	o, err := newProviderOptions(opts...)
+	if err != nil {
+		t.Fatalf("Invalid options: %v", err)
+	}
+	return o
+}
+
+func TestGetIAMTokenForCredential(t *testing.T) {
+	crTokenFile := filepath.Join(t.TempDir(), "vault-token")
+	if err := os.WriteFile(crTokenFile, []byte("cr-token"), 0600); err != nil {
+		t.Fatalf("Unable to write compute resource token: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		credential Credential
+		form       map[string]string
+		expectErr  bool
+	}{
+		{
+			name:       "api key",
+			credential: Credential{Type: APIKey, APIKey: "tenant-api-key"},
+			form:       map[string]string{"apikey": "tenant-api-key"},
+		},
+		{
+			name:       "trusted profile",
+			credential: Credential{Type: TrustedProfile, ProfileName: "tenant-profile", CRTokenFile: crTokenFile},
+			form:       map[string]string{"profile_name": "tenant-profile", "cr_token": "cr-token"},
+		},
+		{
+			name:       "invalid credential",
+			credential: Credential{Type: TrustedProfile},
+			expectErr:  true,
+		},
+	}
+
+	modes := map[Mode]func(t *testing.T, kc k8s_utils.KubernetesClient) CredentialTokenProvider{
+		Managed: func(t *testing.T, kc k8s_utils.KubernetesClient) CredentialTokenProvider {
+			msp, err := initManagedSecretProvider(context.Background(), &kc, zap.NewNop(), newTestProviderOptions(t))
+			if err != nil {
+				t.Fatalf("Unable to initialise managed secret provider: %v", err)
+			}
+			return msp
+		},
+		Unmanaged: func(t *testing.T, kc k8s_utils.KubernetesClient) CredentialTokenProvider {
+			usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t))
+			if err != nil {
+				t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+			}
+			return usp
+		},
+	}
+
+	for mode, newProvider := range modes {
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s", mode, tc.name), func(t *testing.T) {
+				server := newFakeIAMServer(t)
+				provider := newProvider(t, newFakeK8sClient(t, server.URL))
+
+				token, tokenlifetime, err := provider.GetIAMTokenForCredential(context.Background(), tc.credential, false)
+				if tc.expectErr {
+					if err == nil {
+						t.Fatal("Expected an error for the invalid credential")
+					}
+					if len(server.requests()) != 0 {
+						t.Error("Expected no request to IAM for the invalid credential")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Unable to fetch token: %v", err)
+				}
+				if token == "" || tokenlifetime == 0 {
+					t.Errorf("Expected a token, got %q with lifetime %d", token, tokenlifetime)
+				}
+
+				requests := server.requests()
+				if len(requests) != 1 {
+					t.Fatalf("Expected a single request to IAM, got %d", len(requests))
+				}
+				for key, value := range tc.form {
+					if requests[0][key] != value {
+						t.Errorf("Expected %s %q in the request, got %q", key, value, requests[0][key])
+					}
+				}
+
+				// The token is cached, unless a fresh token is required
+				cached, _, err := provider.GetIAMTokenForCredential(context.Background(), tc.credential, false)
+				if err != nil || cached != token || len(server.requests()) != 1 {
+					t.Errorf("Expected the cached token, got %q, %v after %d requests", cached, err, len(server.requests()))
+				}
+				fresh, _, err := provider.GetIAMTokenForCredential(context.Background(), tc.credential, true)
+				if err != nil || fresh == token || len(server.requests()) != 2 {
+					t.Errorf("Expected a fresh token, got %q, %v after %d requests", fresh, err, len(server.requests()))
+				}
+			})
+		}
+	}
+}
+
+func TestContainerAuthenticatorRetry(t *testing.T) {
+	crTokenFile := filepath.Join(t.TempDir(), "vault-token")
+	if err := os.WriteFile(crTokenFile, []byte("cr-token"), 0600); err != nil {
+		t.Fatalf("Unable to write compute resource token: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		status        int
+		latency       time.Duration
+		cancelAfter   time.Duration
+		expectErr     error
+		expectRequest int
+	}{
+		{
+			name:          "token",
+			status:        http.StatusOK,
+			cancelAfter:   time.Minute,
+			expectRequest: 1,
+		},
+		{
+			name:          "not retried on error other than timeout",
+			status:        http.StatusBadRequest,
+			cancelAfter:   time.Minute,
+			expectRequest: 1,
+		},
+		{
+			name:          "retries stop when context is done",
+			status:        http.StatusOK,
+			latency:       200 * time.Millisecond,
+			cancelAfter:   300 * time.Millisecond,
+			expectErr:     context.Canceled,
+			expectRequest: 1,
+		},
+		{
+			name:        "context done before request",
+			status:      http.StatusOK,
+			expectErr:   context.Canceled,
+			cancelAfter: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeIAMServer(t)
+			server.setResponse(time.Hour, tc.status, tc.latency)
+			ca := &containerAuthenticator{
+				logger:                   zap.NewNop(),
+				profileName:              "tenant-profile",
+				crTokenFile:              crTokenFile,
+				tokenExchangeURL:         server.URL,
+				providedTokenExchangeURL: true,
+				client:                   &http.Client{Timeout: 50 * time.Millisecond},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tc.cancelAfter == 0 {
+				cancel()
+			} else {
+				time.AfterFunc(tc.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			token, _, err := ca.GetTokenContext(ctx, true)
+			if elapsed := time.Since(start); elapsed > initialRetryGap {
+				t.Errorf("Expected to return before the next attempt, took %v", elapsed)
+			}
+
+			switch {
+			case tc.expectErr != nil:
+				if !errors.Is(err, tc.expectErr) {
+					t.Errorf("Expected %v, got %v", tc.expectErr, err)
+				}
+			case tc.status == http.StatusOK:
+				if err != nil || token == "" {
+					t.Errorf("Expected a token, got %q, %v", token, err)
+				}
+			default:
+				if err == nil {
+					t.Error("Expected an error")
+				}
+			}
+			if n := len(server.requests()); n != tc.expectRequest {
+				t.Errorf("Expected %d requests, got %d", tc.expectRequest, n)
+			}
+		})
+	}
+}
+
+// watchesStarted returns a channel which receives the resource of every watch started on the fake k8s client. The fake
+// clientset does not replay the changes made between the list and the watch of an informer, unlike the API server,
+// hence the tests wait for the watches before changing the config or the secrets.
+func watchesStarted(t *testing.T, k8sClient k8s_utils.KubernetesClient) <-chan string {
+	t.Helper()
+	clientset, ok := k8sClient.Clientset.(*fake.Clientset)
+	if !ok {
+		t.Fatalf("Expected fake clientset, got %T", k8sClient.Clientset)
+	}
+
+	started := make(chan string, 10)
+	clientset.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		select {
+		case started <- action.GetResource().Resource:
+		default:
+		}
+		return true, w, nil
+	})
+	return started
+}
+
+// waitForWatches waits for the given number of watches to start.
+func waitForWatches(t *testing.T, started <-chan string, count int) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < count; i++ {
+		select {
+		case <-started:
+		case <-timeout:
+			t.Fatalf("Expected %d watches to start, %d started", count, i)
+		}
+	}
+}
@@ -17,68 +17,537 @@
 package secret_provider
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	localutils "github.com/IBM/secret-common-lib/pkg/utils"
-	"github.com/IBM/secret-utils-lib/pkg/config"
 	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
 	"github.com/IBM/secret-utils-lib/pkg/utils"
 	"go.uber.org/zap"
 )
 
-// getEndpoint ...
-func getEndpoint(endpointName, endpointValue string, k8sClient k8s_utils.KubernetesClient, logger *zap.Logger) (string, error) {
-	// Fetching endpoint using Cloud conf
-	cloudConf, err := config.GetCloudConf(logger, k8sClient)
+// EndpointDefinition declares a named endpoint and where it is read from, cloud-conf is preferred over storage-secret-store.
+type EndpointDefinition struct {
+	Name string
 
-	// Check if the endpoint fetched from cloud-conf is reachable
-	if err == nil {
-		switch endpointName {
-		case localutils.RIAAS:
-			endpointValue = cloudConf.RiaasEndpoint
-		case localutils.PrivateRIAAS:
-			endpointValue = cloudConf.PrivateRIAASEndpoint
-		case localutils.ContainerAPIRoute:
-			endpointValue = cloudConf.ContainerAPIRoute
-		case localutils.PrivateContainerAPIRoute:
-			endpointValue = cloudConf.PrivateContainerAPIRoute
+	// CloudConfField is the field of cloud-conf.json in the cloud-conf config map which holds the endpoint, such as riaas_endpoint.
+	CloudConfField string
+
+	// SecretStorePath is the path of the endpoint in slclient.toml of storage-secret-store, the table and the key are
+	// separated by a dot, such as VPC.g2_riaas_endpoint_url. They are matched ignoring case.
+	SecretStorePath string
+
+	// RegionTemplate is used as a last resort, if neither cloud-conf nor storage-secret-store provides the endpoint. The
+	// endpoint is derived by replacing {region} with the region in cloud-conf, such as https://{region}.iaas.cloud.ibm.com.
+	RegionTemplate string
+}
+
+// EndpointSource is the config from which an endpoint was read.
+type EndpointSource string
+
+const (
+	// EndpointSourceCloudConf ...
+	EndpointSourceCloudConf EndpointSource = "cloud-conf"
+
+	// EndpointSourceSecretStore ...
+	EndpointSourceSecretStore EndpointSource = "storage-secret-store"
+
+	// EndpointSourceDerived is set for the endpoints derived from the region using the region template of the endpoint.
+	EndpointSourceDerived EndpointSource = "derived"
+
+	// regionPlaceholder is replaced by the region in the region template of the endpoints.
+	regionPlaceholder = "{region}"
+
+	// cloudConfRegionField ...
+	cloudConfRegionField = "region"
+
+	// privateLabel is the label of the host name of the private endpoints.
+	privateLabel = "private"
+)
+
+// EndpointProvider is implemented by the managed, unmanaged and failover secret providers.
+type EndpointProvider interface {
+	// GetEndpoint returns the endpoint of the given name. If refresh is true, the endpoint is read from the config,
+	// else the one read while initialising the secret provider, or in the last refresh, is returned. The endpoints are
+	// also refreshed by the config watcher, if it is started using WithConfigWatcher.
+	GetEndpoint(ctx context.Context, name string, refresh bool) (string, error)
+
+	// ListEndpoints returns the names of the registered endpoints, in sorted order.
+	ListEndpoints() []string
+
+	// GetEndpointSource returns the config from which the endpoint of the given name was read, it is empty if the
+	// endpoint is not found.
+	GetEndpointSource(name string) (EndpointSource, error)
+
+	// DescribeEndpoints returns the value of the registered endpoints along with where and when they were read, in the
+	// sorted order of their names.
+	DescribeEndpoints() []EndpointInfo
+}
+
+// EndpointInfo describes the value of an endpoint held by the secret provider.
+type EndpointInfo struct {
+	Name  string
+	Value string
+
+	// Source is the config from which the endpoint was read, it is empty if the endpoint was not found.
+	Source EndpointSource
+
+	// ConfigKey is the field in cloud-conf.json or the path in slclient.toml from which the endpoint was read, or the
+	// region template using which it was derived.
+	ConfigKey string
+
+	// FetchedAt is the time at which the endpoint was last read, it is zero if the endpoint was never read.
+	FetchedAt time.Time
+}
+
+// builtinEndpoints are registered in every secret provider, the endpoints provided using WithEndpoint are added to them.
+var builtinEndpoints = []EndpointDefinition{
+	{
+		Name:            localutils.RIAAS,
+		CloudConfField:  "riaas_endpoint",
+		SecretStorePath: "VPC.g2_riaas_endpoint_url",
+		RegionTemplate:  "https://{region}.iaas.cloud.ibm.com",
+	},
+	{
+		Name:            localutils.PrivateRIAAS,
+		CloudConfField:  "riaas_private_endpoint",
+		SecretStorePath: "VPC.g2_riaas_endpoint_private_url",
+		RegionTemplate:  "https://{region}.private.iaas.cloud.ibm.com",
+	},
+	{
+		Name:            localutils.ContainerAPIRoute,
+		CloudConfField:  "containers_api_route",
+		SecretStorePath: "Bluemix.containers_api_route",
+		RegionTemplate:  "https://{region}.containers.cloud.ibm.com",
+	},
+	{
+		Name:            localutils.PrivateContainerAPIRoute,
+		CloudConfField:  "containers_api_route_private",
+		SecretStorePath: "Bluemix.containers_api_route_private",
+		RegionTemplate:  "https://private.{region}.containers.cloud.ibm.com",
+	},
+}
+
+// validate ...
+func (ed EndpointDefinition) validate() error {
+	if ed.Name == "" {
+		return utils.Error{Description: localutils.ErrInvalidEndpointDefinition, BackendError: "name is empty"}
+	}
+	if ed.CloudConfField == "" && ed.SecretStorePath == "" && ed.RegionTemplate == "" {
+		return utils.Error{Description: localutils.ErrInvalidEndpointDefinition, BackendError: "neither cloud-conf field, storage-secret-store path nor region template is provided for " + ed.Name}
+	}
+	return nil
+}
+
+// configKey returns the key of the endpoint in the given config.
+func (ed EndpointDefinition) configKey(source EndpointSource) string {
+	switch source {
+	case EndpointSourceCloudConf:
+		return ed.CloudConfField
+	case EndpointSourceSecretStore:
+		return ed.SecretStorePath
+	case EndpointSourceDerived:
+		return ed.RegionTemplate
+	}
+	return ""
+}
+
+// registeredEndpoint is the value of an endpoint along with the config from which it was read.
+type registeredEndpoint struct {
+	value     string
+	source    EndpointSource
+	fetchedAt time.Time
+}
+
+// endpointRegistry holds the definitions of the endpoints and their values, which are read and refreshed concurrently.
+type endpointRegistry struct {
+	mutex       sync.RWMutex
+	definitions map[string]EndpointDefinition
+	values      map[string]registeredEndpoint
+
+	// region is the one last read from cloud-conf, using which the endpoints are derived
+	region string
+
+	// validation is set if the endpoints read from cloud-conf are validated
+	validation *endpointValidation
+
+	// fallback is set if the endpoints missing in cloud-conf, once it is read, are read from storage-secret-store or
+	// derived from the region, instead of being taken as-is from cloud-conf
+	fallback bool
+
+	// watcher is set if the config watcher is running, the endpoints are then read from its cache
+	watcher *configWatcher
+}
+
+// configReader reads the fields of cloud-conf.json and slclient.toml of storage-secret-store.
+type configReader interface {
+	cloudConfFields(ctx context.Context) (map[string]interface{}, error)
+	secretStoreFields(ctx context.Context) (map[string]interface{}, error)
+}
+
+// apiConfigReader reads the config from the API server.
+type apiConfigReader struct {
+	k8sClient k8s_utils.KubernetesClient
+}
+
+// cloudConfFields ...
+func (r apiConfigReader) cloudConfFields(ctx context.Context) (map[string]interface{}, error) {
+	return getCloudConfFields(ctx, r.k8sClient)
+}
+
+// secretStoreFields ...
+func (r apiConfigReader) secretStoreFields(ctx context.Context) (map[string]interface{}, error) {
+	return getSecretStoreFields(ctx, r.k8sClient)
+}
+
+// reader returns the config watcher if it is running and its cache is synced, else a reader of the API server.
+func (er *endpointRegistry) reader(k8sClient k8s_utils.KubernetesClient) configReader {
+	er.mutex.RLock()
+	watcher := er.watcher
+	er.mutex.RUnlock()
+	if watcher != nil && watcher.synced() {
+		return watcher
+	}
+	return apiConfigReader{k8sClient: k8sClient}
+}
+
+// newEndpointRegistry returns the registry of the built in endpoints and the given ones, which replace the built in
+// endpoints of the same name. The region templates of the endpoints are replaced by the given ones.
+func newEndpointRegistry(definitions []EndpointDefinition, regionTemplates map[string]string, validation *endpointValidation, fallback bool) *endpointRegistry {
+	er := &endpointRegistry{definitions: make(map[string]EndpointDefinition), values: make(map[string]registeredEndpoint), validation: validation, fallback: fallback}
+	for _, definition := range append(append([]EndpointDefinition{}, builtinEndpoints...), definitions...) {
+		er.definitions[definition.Name] = definition
+	}
+	for name, template := range regionTemplates {
+		if definition, ok := er.definitions[name]; ok {
+			definition.RegionTemplate = template
+			er.definitions[name] = definition
 		}
-		if endpointValue != "" {
-			logger.Info(fmt.Sprintf("Fetched %s endpoint from cloud-conf", endpointName), zap.String("endpoint", endpointValue))
-			return endpointValue, nil
+	}
+	return er
+}
+
+// names ...
+func (er *endpointRegistry) names() []string {
+	names := make([]string, 0, len(er.definitions))
+	for name := range er.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get ...
+func (er *endpointRegistry) get(name string) registeredEndpoint {
+	er.mutex.RLock()
+	defer er.mutex.RUnlock()
+	return er.values[name]
+}
+
+// set replaces the endpoint of the given definition with the one read from source, and returns the previous one.
+func (er *endpointRegistry) set(definition EndpointDefinition, value string, source EndpointSource) string {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+
+	previous := er.values[definition.Name].value
+	er.values[definition.Name] = registeredEndpoint{value: value, source: source, fetchedAt: time.Now()}
+	return previous
+}
+
+// describe ...
+func (er *endpointRegistry) describe() []EndpointInfo {
+	names := er.names()
+	infos := make([]EndpointInfo, 0, len(names))
+	for _, name := range names {
+		endpoint := er.get(name)
+		infos = append(infos, EndpointInfo{
+			Name:      name,
+			Value:     endpoint.value,
+			Source:    endpoint.source,
+			ConfigKey: er.definitions[name].configKey(endpoint.source),
+			FetchedAt: endpoint.fetchedAt,
+		})
+	}
+	return infos
+}
+
+// setRegion records the region in the given fields of cloud-conf.json, if present, and returns the region.
+func (er *endpointRegistry) setRegion(cloudConfFields map[string]interface{}) string {
+	er.mutex.Lock()
+	defer er.mutex.Unlock()
+
+	if region := lookupField(cloudConfFields, cloudConfRegionField); region != "" {
+		er.region = region
+	}
+	return er.region
+}
+
+// derive returns the endpoint derived from the region, if the fallback is enabled, the definition has a region template
+// and the region is known.
+func (er *endpointRegistry) derive(definition EndpointDefinition, region string) string {
+	if !er.fallback || definition.RegionTemplate == "" || region == "" {
+		return ""
+	}
+	return strings.ReplaceAll(definition.RegionTemplate, regionPlaceholder, region)
+}
+
+// setFromCloudConf sets the endpoints using the given fields of cloud-conf.json, an endpoint missing in cloud-conf is
+// set empty. If the fallback is enabled and an endpoint is not present in cloud-conf, or if it fails the validation,
+// the one in storage-secret-store is set, else the one derived from the region. The endpoints without a cloud-conf field
+// are read from storage-secret-store.
+func (er *endpointRegistry) setFromCloudConf(ctx context.Context, fields map[string]interface{}, k8sClient k8s_utils.KubernetesClient, logger *zap.Logger) {
+	region := er.setRegion(fields)
+
+	cloudConfEndpoints := make(map[string]string)
+	for _, definition := range er.definitions {
+		if endpoint := lookupField(fields, definition.CloudConfField); definition.CloudConfField != "" && endpoint != "" {
+			cloudConfEndpoints[definition.Name] = endpoint
 		}
 	}
+	validationErrs := er.validateAll(ctx, cloudConfEndpoints)
 
-	// Fetching endpoint using storage-secret-store
-	logger.Info(fmt.Sprintf("Fetching %s endpoint from storage-secret-store", endpointName))
-	data, err := k8s_utils.GetSecretData(k8sClient, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
-	if err != nil {
-		logger.Error(fmt.Sprintf("Unable to fetch %s endpoint from storage-secret-store", endpointName), zap.Error(err))
-		return "", utils.Error{Description: fmt.Sprintf(localutils.ErrorFetchingEndpoint, endpointName), BackendError: err.Error()}
+	// storage-secret-store is read only if an endpoint is not found in cloud-conf
+	var secretStoreFields map[string]interface{}
+	secretStoreRead := false
+	for _, definition := range er.definitions {
+		if definition.CloudConfField != "" {
+			endpoint := cloudConfEndpoints[definition.Name]
+			if endpoint == "" && !er.fallback {
+				er.set(definition, "", "")
+				continue
+			}
+			if endpoint != "" {
+				err := validationErrs[definition.Name]
+				if err == nil {
+					er.set(definition, endpoint, EndpointSourceCloudConf)
+					continue
+				}
+				logger.Warn(fmt.Sprintf("Invalid %s endpoint in cloud-conf", definition.Name), zap.Error(err))
+			}
+		}
+
+		if definition.SecretStorePath != "" {
+			if !secretStoreRead {
+				secretStoreFields, _ = getSecretStoreFields(ctx, k8sClient)
+				secretStoreRead = true
+			}
+			if endpoint := lookupField(secretStoreFields, definition.SecretStorePath); endpoint != "" {
+				er.set(definition, endpoint, EndpointSourceSecretStore)
+				continue
+			}
+		}
+
+		er.setDerived(definition, region, logger)
+	}
+}
+
+// setFromSecretStore sets the endpoints using the given fields of slclient.toml. If an endpoint is not present in
+// storage-secret-store, and is not set already, the one derived from the region is set.
+func (er *endpointRegistry) setFromSecretStore(fields map[string]interface{}, logger *zap.Logger) {
+	region := er.setRegion(nil)
+	for _, definition := range er.definitions {
+		if endpoint := lookupField(fields, definition.SecretStorePath); definition.SecretStorePath != "" && endpoint != "" {
+			er.set(definition, endpoint, EndpointSourceSecretStore)
+			continue
+		}
+		if er.get(definition.Name).value == "" {
+			er.setDerived(definition, region, logger)
+		}
+	}
+}
+
+// setDerived sets the endpoint derived from the region, or an empty endpoint if it cannot be derived.
+func (er *endpointRegistry) setDerived(definition EndpointDefinition, region string, logger *zap.Logger) {
+	endpoint := er.derive(definition, region)
+	if endpoint == "" {
+		er.set(definition, "", "")
+		return
+	}
+	logger.Info(fmt.Sprintf("Derived %s endpoint from the region", definition.Name), zap.String("endpoint", endpoint))
+	er.set(definition, endpoint, EndpointSourceDerived)
+}
+
+// validate validates the endpoint read from cloud-conf, if the validation is enabled.
+func (er *endpointRegistry) validate(ctx context.Context, endpoint string) error {
+	if er.validation == nil {
+		return nil
+	}
+	return er.validation.validate(ctx, endpoint)
+}
+
+// validateAll validates the given endpoints concurrently, the probes share a single deadline so that validating many
+// endpoints does not take longer than validating one. It returns the errors by the name of the endpoints.
+func (er *endpointRegistry) validateAll(ctx context.Context, endpoints map[string]string) map[string]error {
+	errs := make(map[string]error)
+	if er.validation == nil {
+		return errs
 	}
 
-	conf, err := config.ParseConfig(logger, data)
+	ctx, cancel := context.WithTimeout(ctx, endpointProbeTimeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, endpoint := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := er.validation.validate(ctx, endpoint); err != nil {
+				mutex.Lock()
+				errs[name] = err
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// getEndpoint returns the endpoint of the given name, read from the config if refresh is true, along with the previous one.
+// The config is read from the cache of the config watcher, if it is running.
+func (er *endpointRegistry) getEndpoint(ctx context.Context, name string, refresh bool, k8sClient k8s_utils.KubernetesClient, logger *zap.Logger) (string, string, error) {
+	definition, ok := er.definitions[name]
+	if !ok {
+		return "", "", utils.Error{Description: fmt.Sprintf(localutils.ErrUnknownEndpoint, name)}
+	}
+
+	if !refresh {
+		endpoint := er.get(name).value
+		logger.Debug("Returning endpoint", zap.String("name", name), zap.String("Endpoint", endpoint))
+		return endpoint, endpoint, nil
+	}
+
+	endpoint, source, err := er.readEndpoint(ctx, definition, er.reader(k8sClient), logger)
 	if err != nil {
-		logger.Error(fmt.Sprintf("Unable to fetch %s endpoint from storage-secret-store", endpointName), zap.Error(err))
-		return "", utils.Error{Description: fmt.Sprintf(localutils.ErrorFetchingEndpoint, endpointName), BackendError: err.Error()}
+		return "", "", err
 	}
+	return endpoint, er.set(definition, endpoint, source), nil
+}
 
-	switch endpointName {
-	case localutils.RIAAS:
-		endpointValue = conf.VPC.G2EndpointURL
-	case localutils.PrivateRIAAS:
-		endpointValue = conf.VPC.G2EndpointPrivateURL
-	case localutils.ContainerAPIRoute:
-		endpointValue = conf.Bluemix.APIEndpointURL
-	case localutils.PrivateContainerAPIRoute:
-		endpointValue = conf.Bluemix.PrivateAPIRoute
+// getSource ...
+func (er *endpointRegistry) getSource(name string) (EndpointSource, error) {
+	if _, ok := er.definitions[name]; !ok {
+		return "", utils.Error{Description: fmt.Sprintf(localutils.ErrUnknownEndpoint, name)}
 	}
+	return er.get(name).source, nil
+}
+
+// endpointRead is the result of reading an endpoint from the config.
+type endpointRead struct {
+	value  string
+	source EndpointSource
+	err    error
+}
+
+// readEndpoint reads the given endpoint from cloud-conf, else from storage-secret-store. If the validation is enabled and
+// the endpoint in cloud-conf fails it, the one in storage-secret-store is returned. If neither provides the endpoint and
+// the fallback is enabled, the one derived from the region is returned. If the endpoint in cloud-conf failed the
+// validation and is not replaced, the validation error is returned.
+func (er *endpointRegistry) readEndpoint(ctx context.Context, definition EndpointDefinition, reader configReader, logger *zap.Logger) (string, EndpointSource, error) {
+	read := er.readEndpoints(ctx, []EndpointDefinition{definition}, reader, logger)[definition.Name]
+	return read.value, read.source, read.err
+}
 
-	if endpointValue != "" {
-		logger.Info(fmt.Sprintf("Fetched %s endpoint from storage-secret-store", endpointName), zap.String("endpoint", endpointValue))
-		return endpointValue, nil
+// readEndpoints reads the given endpoints same as readEndpoint, by the name of the endpoints. cloud-conf and
+// storage-secret-store are read once, and the endpoints in cloud-conf are validated concurrently under one deadline.
+func (er *endpointRegistry) readEndpoints(ctx context.Context, definitions []EndpointDefinition, reader configReader, logger *zap.Logger) map[string]endpointRead {
+	// Fetching endpoints using Cloud conf
+	fields, err := reader.cloudConfFields(ctx)
+	region := er.setRegion(fields)
+	cloudConfEndpoints := make(map[string]string)
+	if err == nil {
+		for _, definition := range definitions {
+			if endpoint := lookupField(fields, definition.CloudConfField); definition.CloudConfField != "" && endpoint != "" {
+				cloudConfEndpoints[definition.Name] = endpoint
+			}
+		}
 	}
+	validationErrs := er.validateAll(ctx, cloudConfEndpoints)
+
+	// storage-secret-store is read only if an endpoint is not found in cloud-conf
+	var secretStoreFields map[string]interface{}
+	var secretStoreErr error
+	secretStoreRead := false
+
+	reads := make(map[string]endpointRead)
+	for _, definition := range definitions {
+		endpointName := definition.Name
+		if endpointValue := cloudConfEndpoints[endpointName]; endpointValue != "" {
+			err := validationErrs[endpointName]
+			if err == nil {
+				logger.Info(fmt.Sprintf("Fetched %s endpoint from cloud-conf", endpointName), zap.String("endpoint", endpointValue))
+				reads[endpointName] = endpointRead{value: endpointValue, source: EndpointSourceCloudConf}
+				continue
+			}
+			if definition.SecretStorePath == "" && definition.RegionTemplate == "" {
+				logger.Error(fmt.Sprintf("Invalid %s endpoint in cloud-conf", endpointName), zap.Error(err))
+				reads[endpointName] = endpointRead{err: err}
+				continue
+			}
+			logger.Warn(fmt.Sprintf("Invalid %s endpoint in cloud-conf", endpointName), zap.Error(err))
+		}
 
-	logger.Error(fmt.Sprintf(localutils.ErrEmptyEndpoint, endpointName))
-	return "", utils.Error{Description: fmt.Sprintf(localutils.ErrEmptyEndpoint, endpointName)}
+		// Fetching endpoint using storage-secret-store
+		if definition.SecretStorePath != "" {
+			if !secretStoreRead {
+				logger.Info("Fetching endpoints from storage-secret-store")
+				secretStoreFields, secretStoreErr = reader.secretStoreFields(ctx)
+				secretStoreRead = true
+				if secretStoreErr != nil {
+					logger.Warn("Unable to fetch endpoints from storage-secret-store", zap.Error(secretStoreErr))
+				}
+			}
+			if endpointValue := lookupField(secretStoreFields, definition.SecretStorePath); endpointValue != "" {
+				logger.Info(fmt.Sprintf("Fetched %s endpoint from storage-secret-store", endpointName), zap.String("endpoint", endpointValue))
+				reads[endpointName] = endpointRead{value: endpointValue, source: EndpointSourceSecretStore}
+				continue
+			}
+		}
+
+		// Deriving endpoint using the region, as a last resort
+		if endpointValue := er.derive(definition, region); endpointValue != "" {
+			logger.Info(fmt.Sprintf("Derived %s endpoint from the region", endpointName), zap.String("endpoint", endpointValue))
+			reads[endpointName] = endpointRead{value: endpointValue, source: EndpointSourceDerived}
+			continue
+		}
+
+		// The endpoint in cloud-conf which failed the validation is the cause, if nothing replaces it
+		if err := validationErrs[endpointName]; err != nil {
+			logger.Error(fmt.Sprintf("Invalid %s endpoint in cloud-conf, and no other source provides it", endpointName), zap.Error(err))
+			reads[endpointName] = endpointRead{err: err}
+			continue
+		}
+		if definition.SecretStorePath != "" && secretStoreErr != nil {
+			logger.Error(fmt.Sprintf("Unable to fetch %s endpoint from storage-secret-store", endpointName), zap.Error(secretStoreErr))
+			reads[endpointName] = endpointRead{err: utils.Error{Description: fmt.Sprintf(localutils.ErrorFetchingEndpoint, endpointName), BackendError: secretStoreErr.Error()}}
+			continue
+		}
+		logger.Error(fmt.Sprintf(localutils.ErrEmptyEndpoint, endpointName))
+		reads[endpointName] = endpointRead{err: utils.Error{Description: fmt.Sprintf(localutils.ErrEmptyEndpoint, endpointName)}}
+	}
+	return reads
+}
+
+// isPrivateEndpoint returns true if the given URL is a private endpoint, that is, a label of its host name is private,
+// such as https://private.iam.cloud.ibm.com or https://us-south.private.iaas.cloud.ibm.com.
+func isPrivateEndpoint(endpoint string) bool {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(u.Hostname(), ".") {
+		if strings.EqualFold(label, privateLabel) {
+			return true
+		}
+	}
+	return false
 }
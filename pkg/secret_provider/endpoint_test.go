@@ -0,0 +1,348 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	secret_provider "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	"github.com/IBM/secret-common-lib/pkg/secret_provider/secretsidecartest"
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	sp "github.com/IBM/secret-utils-lib/pkg/secret_provider"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// goroutines is the number of goroutines reading the endpoints concurrently, run the tests with -race.
+	goroutines = 20
+
+	// iterations is the number of times each goroutine reads every endpoint.
+	iterations = 20
+)
+
+// cloudConf returns cloud-conf.json holding the endpoints of the given region.
+func cloudConf(region string) string {
+	return fmt.Sprintf(`{"region": %q, "riaas_endpoint": "https://%[1]s.iaas.cloud.ibm.com", "riaas_private_endpoint": "https://%[1]s.private.iaas.cloud.ibm.com", "containers_api_route": "https://%[1]s.containers.cloud.ibm.com", "containers_api_route_private": "https://private.%[1]s.containers.cloud.ibm.com", "token_exchange_url": "https://iam.cloud.ibm.com"}`, region)
+}
+
+// newFakeK8sClient returns a fake k8s client holding ibm-cloud-credentials and cloud-conf of the given region.
+func newFakeK8sClient(t *testing.T, region string) k8s_utils.KubernetesClient {
+	t.Helper()
+	k8sClient, err := k8s_utils.FakeGetk8sClientSet()
+	if err != nil {
+		t.Fatalf("Unable to create fake k8s client: %v", err)
+	}
+	if err := k8s_utils.FakeCreateSecret(k8sClient, "iam", "../../test-fixtures/secrets/ibm-cloud-credentials/iam-cloud-provider.env"); err != nil {
+		t.Fatalf("Unable to create ibm-cloud-credentials: %v", err)
+	}
+
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cloud-conf", Namespace: k8sClient.Namespace}, Data: map[string]string{"cloud-conf.json": cloudConf(region)}}
+	if _, err := k8sClient.Clientset.CoreV1().ConfigMaps(k8sClient.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create cloud-conf: %v", err)
+	}
+	return k8sClient
+}
+
+// setRegion updates cloud-conf to hold the endpoints of the given region.
+func setRegion(t *testing.T, k8sClient k8s_utils.KubernetesClient, region string) {
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cloud-conf", Namespace: k8sClient.Namespace}, Data: map[string]string{"cloud-conf.json": cloudConf(region)}}
+	if _, err := k8sClient.Clientset.CoreV1().ConfigMaps(k8sClient.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Errorf("Unable to update cloud-conf: %v", err)
+	}
+}
+
+// newTestSecretProvider initialises the secret provider of the given mode using the fake k8s client, the managed secret
+// provider uses a fake sidecar.
+func newTestSecretProvider(t *testing.T, mode secret_provider.Mode, k8sClient k8s_utils.KubernetesClient, opts ...secret_provider.Option) sp.SecretProviderInterface {
+	t.Helper()
+	opts = append(opts, secret_provider.WithMode(mode), secret_provider.WithLogger(zap.NewNop()))
+	if mode == secret_provider.Managed {
+		server, err := secretsidecartest.NewServer()
+		if err != nil {
+			t.Fatalf("Unable to start fake sidecar: %v", err)
+		}
+		t.Cleanup(server.Close)
+		opts = append(opts, server.Options()...)
+	}
+
+	provider, err := secret_provider.NewSecretProviderWithOptions(&k8sClient, opts...)
+	if err != nil {
+		t.Fatalf("Unable to initialise %s secret provider: %v", mode, err)
+	}
+	if closer, ok := provider.(interface{ Close() error }); ok {
+		t.Cleanup(func() { _ = closer.Close() })
+	}
+	return provider
+}
+
+// expectedEndpoints returns the endpoints of the given region, by the name of the endpoint.
+func expectedEndpoints(region string) map[string]string {
+	return map[string]string{
+		localutils.RIAAS:                    "https://" + region + ".iaas.cloud.ibm.com",
+		localutils.PrivateRIAAS:             "https://" + region + ".private.iaas.cloud.ibm.com",
+		localutils.ContainerAPIRoute:        "https://" + region + ".containers.cloud.ibm.com",
+		localutils.PrivateContainerAPIRoute: "https://private." + region + ".containers.cloud.ibm.com",
+	}
+}
+
+// readEndpoints reads every built in endpoint using its getter, refreshing it from the config if readConfig is true.
+func readEndpoints(provider sp.SecretProviderInterface, readConfig bool) (map[string]string, error) {
+	getters := map[string]func(bool) (string, error){
+		localutils.RIAAS:                    provider.GetRIAASEndpoint,
+		localutils.PrivateRIAAS:             provider.GetPrivateRIAASEndpoint,
+		localutils.ContainerAPIRoute:        provider.GetContainerAPIRoute,
+		localutils.PrivateContainerAPIRoute: provider.GetPrivateContainerAPIRoute,
+	}
+
+	endpoints := make(map[string]string)
+	for name, get := range getters {
+		endpoint, err := get(readConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		endpoints[name] = endpoint
+	}
+	return endpoints, nil
+}
+
+// checkEndpoints returns an error if an endpoint is not one of the given regions.
+func checkEndpoints(endpoints map[string]string, regions ...string) error {
+	for name, endpoint := range endpoints {
+		found := false
+		for _, region := range regions {
+			if expectedEndpoints(region)[name] == endpoint {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unexpected %s endpoint %q", name, endpoint)
+		}
+	}
+	return nil
+}
+
+// hammerEndpoints reads the endpoints from many goroutines, refreshing them in half of the calls, while cloud-conf is
+// switched between the two regions. The endpoints read must belong to either of the regions.
+func hammerEndpoints(t *testing.T, provider sp.SecretProviderInterface, k8sClient k8s_utils.KubernetesClient) {
+	endpointProvider := provider.(secret_provider.EndpointProvider)
+	cloudConfigProvider := provider.(interface {
+		GetRegion() string
+		GetResourceGroupID() string
+		GetTokenExchangeURL() string
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				readConfig := (i+j)%2 == 0
+				endpoints, err := readEndpoints(provider, readConfig)
+				if err == nil {
+					err = checkEndpoints(endpoints, "us-south", "eu-de")
+				}
+				if err == nil {
+					_, err = endpointProvider.GetEndpoint(context.Background(), localutils.RIAAS, readConfig)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				_ = endpointProvider.DescribeEndpoints()
+				_, _ = endpointProvider.GetEndpointSource(localutils.PrivateRIAAS)
+				_ = cloudConfigProvider.GetRegion()
+				_ = cloudConfigProvider.GetResourceGroupID()
+				_ = cloudConfigProvider.GetTokenExchangeURL()
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < iterations; j++ {
+			region := "eu-de"
+			if j%2 == 1 {
+				region = "us-south"
+			}
+			setRegion(t, k8sClient, region)
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// waitForRegion waits until the endpoints held by the secret provider, and its region, are of the given region.
+func waitForRegion(provider sp.SecretProviderInterface, region string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		endpoints, err := readEndpoints(provider, false)
+		if err == nil {
+			err = checkEndpoints(endpoints, region)
+		}
+		if err == nil {
+			if providerRegion := provider.(secret_provider.CloudConfigProvider).GetRegion(); providerRegion != region {
+				err = fmt.Errorf("unexpected region %q", providerRegion)
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("endpoints did not converge to %s: %v", region, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestEndpointsConcurrent(t *testing.T) {
+	for _, mode := range []secret_provider.Mode{secret_provider.Managed, secret_provider.Unmanaged} {
+		for _, configWatcher := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/config-watcher=%v", mode, configWatcher), func(t *testing.T) {
+				k8sClient := newFakeK8sClient(t, "us-south")
+				var opts []secret_provider.Option
+				started := secret_provider.WatchesStarted(t, k8sClient)
+				if configWatcher {
+					opts = append(opts, secret_provider.WithConfigWatcher())
+				}
+				provider := newTestSecretProvider(t, mode, k8sClient, opts...)
+				if configWatcher {
+					// cloud-conf and storage-secret-store are watched
+					secret_provider.WaitForWatches(t, started, 2)
+				}
+
+				endpoints, err := readEndpoints(provider, false)
+				if err != nil {
+					t.Fatalf("Unable to read endpoints: %v", err)
+				}
+				if err := checkEndpoints(endpoints, "us-south"); err != nil {
+					t.Fatal(err)
+				}
+
+				hammerEndpoints(t, provider, k8sClient)
+
+				// Once cloud-conf settles, the refreshed endpoints are of its region
+				setRegion(t, k8sClient, "jp-tok")
+				if !configWatcher {
+					endpoints, err = readEndpoints(provider, true)
+					if err != nil {
+						t.Fatalf("Unable to read endpoints: %v", err)
+					}
+					if err := checkEndpoints(endpoints, "jp-tok"); err != nil {
+						t.Error(err)
+					}
+					return
+				}
+
+				// The config watcher replaces the endpoints and the region held by the secret provider, without a refresh
+				if err := waitForRegion(provider, "jp-tok", 5*time.Second); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+func TestGetEndpointValidationError(t *testing.T) {
+	const (
+		unreachable = "https://cos.unreachable.cloud.ibm.com"
+		secretStore = "https://s3.direct.us-south.cloud-object-storage.appdomain.cloud"
+	)
+
+	testCases := []struct {
+		name             string
+		definition       secret_provider.EndpointDefinition
+		secretStore      string
+		expectedEndpoint string
+	}{
+		{
+			name:       "no other source",
+			definition: secret_provider.EndpointDefinition{Name: "COS", CloudConfField: "cos_endpoint"},
+		},
+		{
+			name:       "storage-secret-store missing",
+			definition: secret_provider.EndpointDefinition{Name: "COS", CloudConfField: "cos_endpoint", SecretStorePath: "VPC.cos_endpoint_url"},
+		},
+		{
+			name:        "storage-secret-store without the endpoint",
+			definition:  secret_provider.EndpointDefinition{Name: "COS", CloudConfField: "cos_endpoint", SecretStorePath: "VPC.cos_endpoint_url"},
+			secretStore: "[VPC]\n  g2_riaas_endpoint_url = \"https://us-south.iaas.cloud.ibm.com\"\n",
+		},
+		{
+			name:             "replaced by storage-secret-store",
+			definition:       secret_provider.EndpointDefinition{Name: "COS", CloudConfField: "cos_endpoint", SecretStorePath: "VPC.cos_endpoint_url"},
+			secretStore:      fmt.Sprintf("[VPC]\n  cos_endpoint_url = %q\n", secretStore),
+			expectedEndpoint: secretStore,
+		},
+	}
+
+	probe := func(ctx context.Context, endpoint string) error {
+		if endpoint == unreachable {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	for _, mode := range []secret_provider.Mode{secret_provider.Managed, secret_provider.Unmanaged} {
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/%s", mode, tc.name), func(t *testing.T) {
+				k8sClient := newFakeK8sClient(t, "us-south")
+				conf := strings.TrimSuffix(cloudConf("us-south"), "}") + fmt.Sprintf(", %q: %q}", "cos_endpoint", unreachable)
+				cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cloud-conf", Namespace: k8sClient.Namespace}, Data: map[string]string{"cloud-conf.json": conf}}
+				if _, err := k8sClient.Clientset.CoreV1().ConfigMaps(k8sClient.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+					t.Fatalf("Unable to update cloud-conf: %v", err)
+				}
+				if tc.secretStore != "" {
+					secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: utils.STORAGE_SECRET_STORE_SECRET, Namespace: k8sClient.Namespace}, Data: map[string][]byte{utils.SECRET_STORE_FILE: []byte(tc.secretStore)}}
+					if _, err := k8sClient.Clientset.CoreV1().Secrets(k8sClient.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+						t.Fatalf("Unable to create storage-secret-store: %v", err)
+					}
+				}
+				provider := newTestSecretProvider(t, mode, k8sClient, secret_provider.WithEndpoint(tc.definition), secret_provider.WithEndpointValidation(probe))
+
+				endpoint, err := provider.(secret_provider.EndpointProvider).GetEndpoint(context.Background(), tc.definition.Name, true)
+				if tc.expectedEndpoint != "" {
+					if err != nil || endpoint != tc.expectedEndpoint {
+						t.Errorf("Expected %q, got %q, %v", tc.expectedEndpoint, endpoint, err)
+					}
+					return
+				}
+
+				var providerErr utils.Error
+				if !errors.As(err, &providerErr) || providerErr.Description != fmt.Sprintf(localutils.ErrUnreachableEndpoint, unreachable) || !strings.Contains(providerErr.BackendError, "connection refused") {
+					t.Errorf("Expected the validation error of %s, got %q, %v", unreachable, endpoint, err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+)
+
+const (
+	// endpointProbeTimeout is the time for which an endpoint is probed.
+	endpointProbeTimeout = 10 * time.Second
+)
+
+// EndpointProbe checks if the given endpoint is reachable, the check is bound to ctx.
+type EndpointProbe func(ctx context.Context, endpoint string) error
+
+// Dialer is implemented by net.Dialer, it can be used to probe the endpoints through a proxy.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// TCPProbe returns a probe which dials the host and port of the endpoint using the given dialer, net.Dialer if it is nil.
+// The port defaults to 443 for https and 80 for http.
+func TCPProbe(dialer Dialer) EndpointProbe {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return func(ctx context.Context, endpoint string) error {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+
+		port := u.Port()
+		if port == "" {
+			port = "443"
+			if u.Scheme == "http" {
+				port = "80"
+			}
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPHeadProbe returns a probe which makes a HEAD request to the endpoint using the given client, http.DefaultClient if
+// it is nil. Any response is treated as reachable, since the endpoints need not serve a HEAD request.
+func HTTPHeadProbe(client *http.Client) EndpointProbe {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context, endpoint string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+}
+
+// endpointValidation holds the validation of the endpoints read from cloud-conf, provided using WithEndpointValidation.
+type endpointValidation struct {
+	probe EndpointProbe
+}
+
+// validate checks that the endpoint is an http or https URL with a host, and probes it if a probe is provided.
+func (ev *endpointValidation) validate(ctx context.Context, endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return utils.Error{Description: fmt.Sprintf(localutils.ErrInvalidEndpoint, endpoint), BackendError: err.Error()}
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return utils.Error{Description: fmt.Sprintf(localutils.ErrInvalidEndpoint, endpoint), BackendError: "scheme must be http or https"}
+	}
+	if u.Hostname() == "" {
+		return utils.Error{Description: fmt.Sprintf(localutils.ErrInvalidEndpoint, endpoint), BackendError: "host is empty"}
+	}
+
+	if ev.probe == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, endpointProbeTimeout)
+	defer cancel()
+	if err := ev.probe(ctx, endpoint); err != nil {
+		return utils.Error{Description: fmt.Sprintf(localutils.ErrUnreachableEndpoint, endpoint), BackendError: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"sync"
+
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+)
+
+// EventType ...
+type EventType string
+
+const (
+	// CredentialRotated is emitted when the api key or the trusted profile in the secret is changed.
+	CredentialRotated EventType = "CredentialRotated"
+
+	// SecretSourceSwitched is emitted when the credentials are read from a different secret, ibm-cloud-credentials or storage-secret-store.
+	SecretSourceSwitched EventType = "SecretSourceSwitched"
+
+	// AuthTypeChanged is emitted when the auth type (iam, pod-identity) is changed.
+	AuthTypeChanged EventType = "AuthTypeChanged"
+
+	// EndpointsChanged is emitted when an endpoint read from the config is different from the one held by the secret provider.
+	EndpointsChanged EventType = "EndpointsChanged"
+)
+
+// Event is delivered to the callbacks registered using Subscribe. Only the fields relevant to the type are set.
+type Event struct {
+	Type EventType
+
+	// Secret and PreviousSecret are the names of the k8s secrets, for SecretSourceSwitched.
+	Secret         string
+	PreviousSecret string
+
+	// AuthType and PreviousAuthType are set for AuthTypeChanged.
+	AuthType         string
+	PreviousAuthType string
+
+	// Endpoint is the name of the endpoint such as RIAAS, Value and PreviousValue are its URLs, for EndpointsChanged.
+	Endpoint      string
+	Value         string
+	PreviousValue string
+}
+
+// Notifier is implemented by the managed, unmanaged and failover secret providers.
+type Notifier interface {
+	// Subscribe registers the given callback, which is called for every event until the returned function is called.
+	// The callbacks are called in order, on the goroutine which observed the change, hence they must not block.
+	Subscribe(callback func(Event)) (unsubscribe func())
+}
+
+// subscribers holds the registered callbacks, the zero value is ready to use.
+type subscribers struct {
+	mutex     sync.RWMutex
+	callbacks []subscription
+	nextID    int
+}
+
+// subscription ...
+type subscription struct {
+	id       int
+	callback func(Event)
+}
+
+// subscribe ...
+func (s *subscribers) subscribe(callback func(Event)) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	s.callbacks = append(s.callbacks, subscription{id: id, callback: callback})
+
+	return func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for i, sub := range s.callbacks {
+			if sub.id == id {
+				s.callbacks = append(s.callbacks[:i:i], s.callbacks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// publish calls the registered callbacks in the order of registration.
+func (s *subscribers) publish(event Event) {
+	s.mutex.RLock()
+	callbacks := s.callbacks
+	s.mutex.RUnlock()
+
+	for _, sub := range callbacks {
+		sub.callback(event)
+	}
+}
+
+// publishEndpointChange publishes EndpointsChanged if the endpoint read from the config is different from the previous one.
+func (s *subscribers) publishEndpointChange(endpoint, previousValue, value string) {
+	if previousValue == value {
+		return
+	}
+	s.publish(Event{Type: EndpointsChanged, Endpoint: endpoint, Value: value, PreviousValue: previousValue})
+}
+
+// secretSource returns the name of the k8s secret from which the credentials of the given auth type are read.
+func secretSource(authType string) string {
+	if authType == utils.DEFAULT {
+		return utils.STORAGE_SECRET_STORE_SECRET
+	}
+	return utils.IBMCLOUD_CREDENTIALS_SECRET
+}
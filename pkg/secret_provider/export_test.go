@@ -0,0 +1,54 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"testing"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+)
+
+// The fakes below are exported for the tests of package secret_provider_test, which use the fake sidecar.
+
+// FakeIAMServer ...
+type FakeIAMServer = fakeIAMServer
+
+// NewFakeIAMServer ...
+func NewFakeIAMServer(t *testing.T) *FakeIAMServer {
+	return newFakeIAMServer(t)
+}
+
+// RequestCount returns the number of token requests received so far.
+func (s *fakeIAMServer) RequestCount() int {
+	return len(s.requests())
+}
+
+// NewFakeK8sClientWithIAM returns a fake k8s client holding ibm-cloud-credentials, and cloud-conf with the given token exchange URL.
+func NewFakeK8sClientWithIAM(t *testing.T, tokenExchangeURL string) k8s_utils.KubernetesClient {
+	return newFakeK8sClient(t, tokenExchangeURL)
+}
+
+// WatchesStarted ...
+func WatchesStarted(t *testing.T, k8sClient k8s_utils.KubernetesClient) <-chan string {
+	return watchesStarted(t, k8sClient)
+}
+
+// WaitForWatches ...
+func WaitForWatches(t *testing.T, started <-chan string, count int) {
+	t.Helper()
+	waitForWatches(t, started, count)
+}
@@ -0,0 +1,401 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultFallbackRetryInterval is the time for which the unmanaged secret provider is used, before the sidecar is tried again.
+	defaultFallbackRetryInterval = 30 * time.Second
+
+	// sidecarProbeTimeout is the time for which the sidecar is probed, before the calls are routed to it.
+	sidecarProbeTimeout = 2 * time.Second
+)
+
+// FailoverSecretProvider routes the calls to the managed secret provider when the sidecar is reachable, and falls back to
+// the unmanaged secret provider, which uses the same k8s secret, when it is not. Once the sidecar is reachable again,
+// the calls are routed to it.
+type FailoverSecretProvider struct {
+	managed       *ManagedSecretProvider
+	unmanaged     *UnmanagedSecretProvider
+	logger        *zap.Logger
+	providerType  string
+	retryInterval time.Duration
+
+	// probeMutex serialises probing the sidecar, so that the concurrent callers wait for a single probe
+	probeMutex sync.Mutex
+
+	// mutex guards the state of the sidecar below, which is changed only by the probes and the calls which were not
+	// cancelled by the caller
+	mutex              sync.Mutex
+	sidecarInitialised bool
+	sidecarDown        bool
+	nextSidecarAttempt time.Time
+}
+
+// newFailoverSecretProvider initialises both managed and unmanaged secret providers. Initialisation fails only if
+// the sidecar is unreachable and the unmanaged secret provider cannot be initialised either.
+func newFailoverSecretProvider(k8sClient *k8s_utils.KubernetesClient, logger *zap.Logger, opts *providerOptions) (*FailoverSecretProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
+	defer cancel()
+
+	// Unless a retry policy is provided, the sidecar is given up on in a few seconds, so that the calls fall back
+	managedOpts := *opts
+	if !managedOpts.sidecarRetrySet {
+		managedOpts.sidecarRetry = failoverSidecarRetryPolicy()
+	}
+	msp, err := initManagedSecretProvider(ctx, k8sClient, logger, &managedOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	fsp := &FailoverSecretProvider{managed: msp, logger: logger, providerType: opts.providerType, retryInterval: opts.fallbackRetryInterval}
+
+	// Both secret providers use the same k8s client. The endpoints are provided by the managed secret provider, hence
+	// only it watches the config. The unmanaged secret provider is used only while the sidecar is unreachable, hence it
+	// neither refreshes the token nor watches the secret in the background
+	unmanagedOpts := *opts
+	unmanagedOpts.configWatcher = false
+	unmanagedOpts.tokenRefreshFraction = 0
+	unmanagedOpts.secretWatcher = false
+	fsp.unmanaged, err = newUnmanagedSecretProvider(&msp.k8sClient, logger, &unmanagedOpts)
+	if err != nil {
+		logger.Warn("Unable to initialize unmanaged secret provider, fallback is disabled", zap.Error(err))
+	}
+
+	if opts.healthCheckInterval > 0 {
+		msp.startHealthChecker(opts.healthCheckInterval)
+	}
+	if opts.configWatcher {
+		// The config held by the unmanaged secret provider is reloaded along with the managed one
+		reloadConfig := msp.reloadConfig
+		if usp := fsp.unmanaged; usp != nil {
+			reloadConfig = func(reader configReader) {
+				msp.reloadConfig(reader)
+				usp.reloadConfig(reader)
+			}
+		}
+		msp.endpoints.startWatcher(msp.k8sClient, logger, msp.subscribers.publishEndpointChange, reloadConfig)
+	}
+
+	// If the sidecar does not respond to the probe, the unmanaged secret provider is used right away
+	if fsp.unmanaged != nil {
+		if err := fsp.checkSidecarHealth(); err != nil {
+			fsp.markSidecarDown(err)
+			logger.Info("Initialized failover secret provider")
+			return fsp, nil
+		}
+	}
+
+	sidecarErr := msp.initSidecar(ctx, opts.providerType)
+	if sidecarErr == nil {
+		fsp.sidecarInitialised = true
+		logger.Info("Initialized failover secret provider")
+		return fsp, nil
+	}
+
+	if !IsSidecarUnreachable(sidecarErr) || fsp.unmanaged == nil {
+		logger.Error("Error initiliazing failover secret provider", zap.Error(sidecarErr))
+		_ = fsp.Close()
+		return nil, sidecarErr
+	}
+
+	fsp.markSidecarDown(sidecarErr)
+	logger.Info("Initialized failover secret provider")
+	return fsp, nil
+}
+
+// useSidecar returns true if the call needs to be routed to the sidecar. If the background health checker is running,
+// its result decides. Else the calls are routed to the sidecar while the connection to it is ready, and once it is not,
+// or the retry interval has passed after the sidecar was found unreachable, the sidecar is probed.
+func (fsp *FailoverSecretProvider) useSidecar() bool {
+	if fsp.unmanaged == nil {
+		return true
+	}
+
+	if known, err := fsp.managed.healthState(); known {
+		if err != nil {
+			fsp.markSidecarDown(err)
+			return false
+		}
+		fsp.markSidecarUp()
+	}
+
+	if use, known := fsp.sidecarState(); known {
+		return use
+	}
+
+	fsp.probeMutex.Lock()
+	defer fsp.probeMutex.Unlock()
+
+	// The sidecar may have been probed by a concurrent call, while this one was waiting
+	if use, known := fsp.sidecarState(); known {
+		return use
+	}
+
+	if err := fsp.probeSidecar(); err != nil {
+		fsp.markSidecarDown(err)
+		return false
+	}
+
+	fsp.mutex.Lock()
+	fsp.sidecarInitialised = true
+	fsp.mutex.Unlock()
+	fsp.markSidecarUp()
+	return true
+}
+
+// sidecarState returns whether the calls need to be routed to the sidecar, known is false if the sidecar needs to be probed.
+func (fsp *FailoverSecretProvider) sidecarState() (use bool, known bool) {
+	fsp.mutex.Lock()
+	sidecarDown, nextSidecarAttempt, sidecarInitialised := fsp.sidecarDown, fsp.nextSidecarAttempt, fsp.sidecarInitialised
+	fsp.mutex.Unlock()
+
+	switch {
+	case sidecarDown && time.Now().Before(nextSidecarAttempt):
+		return false, true
+	case !sidecarDown && sidecarInitialised && fsp.managed.isConnected():
+		return true, true
+	}
+	return false, false
+}
+
+// probeSidecar checks the health of the sidecar, and initialises it if it was never reached. The probe is made with its
+// own short timeout, so that neither the deadline of a caller nor the retry policy of the calls decides the routing.
+func (fsp *FailoverSecretProvider) probeSidecar() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarProbeTimeout)
+	defer cancel()
+
+	if err := fsp.managed.Healthy(ctx); err != nil {
+		return err
+	}
+
+	fsp.mutex.Lock()
+	sidecarInitialised := fsp.sidecarInitialised
+	fsp.mutex.Unlock()
+	if sidecarInitialised {
+		return nil
+	}
+	return fsp.managed.initSidecar(ctx, fsp.providerType)
+}
+
+// checkSidecarHealth checks the health of the sidecar with the timeout of the probe.
+func (fsp *FailoverSecretProvider) checkSidecarHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarProbeTimeout)
+	defer cancel()
+	return fsp.managed.Healthy(ctx)
+}
+
+// markSidecarDown routes the calls to the unmanaged secret provider for the retry interval.
+func (fsp *FailoverSecretProvider) markSidecarDown(err error) {
+	fsp.mutex.Lock()
+	defer fsp.mutex.Unlock()
+
+	if !fsp.sidecarDown {
+		fsp.logger.Warn("Sidecar is unreachable, falling back to unmanaged secret provider", zap.Error(err))
+	}
+	fsp.sidecarDown = true
+	fsp.nextSidecarAttempt = time.Now().Add(fsp.retryInterval)
+}
+
+// markSidecarUp routes the calls to the sidecar.
+func (fsp *FailoverSecretProvider) markSidecarUp() {
+	fsp.mutex.Lock()
+	defer fsp.mutex.Unlock()
+
+	if fsp.sidecarDown {
+		fsp.logger.Info("Sidecar is reachable, switching back to managed secret provider")
+	}
+	fsp.sidecarDown = false
+}
+
+// getToken routes the given token fetch to the sidecar if it is reachable, else to the unmanaged secret provider.
+func (fsp *FailoverSecretProvider) getToken(ctx context.Context, managed, unmanaged func() (string, uint64, error)) (string, uint64, error) {
+	if fsp.useSidecar() {
+		token, tokenlifetime, err := managed()
+		if err == nil {
+			fsp.markSidecarUp()
+			return token, tokenlifetime, nil
+		}
+		if !IsSidecarUnreachable(err) || fsp.unmanaged == nil {
+			return token, tokenlifetime, err
+		}
+		// If the caller's context is done, the sidecar may not be unreachable, hence the routing is not changed
+		if ctx.Err() != nil {
+			return token, tokenlifetime, err
+		}
+		fsp.markSidecarDown(err)
+	}
+
+	return unmanaged()
+}
+
+// GetDefaultIAMToken ...
+func (fsp *FailoverSecretProvider) GetDefaultIAMToken(freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
+	defer cancel()
+	return fsp.GetDefaultIAMTokenContext(ctx, freshTokenRequired, reasonForCall...)
+}
+
+// GetDefaultIAMTokenContext ...
+func (fsp *FailoverSecretProvider) GetDefaultIAMTokenContext(ctx context.Context, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	return fsp.getToken(ctx, func() (string, uint64, error) {
+		return fsp.managed.GetDefaultIAMTokenContext(ctx, freshTokenRequired, reasonForCall...)
+	}, func() (string, uint64, error) {
+		return fsp.unmanaged.GetDefaultIAMTokenContext(ctx, freshTokenRequired, reasonForCall...)
+	})
+}
+
+// GetIAMToken ...
+func (fsp *FailoverSecretProvider) GetIAMToken(secret string, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
+	defer cancel()
+	return fsp.GetIAMTokenContext(ctx, secret, freshTokenRequired, reasonForCall...)
+}
+
+// GetIAMTokenContext ...
+func (fsp *FailoverSecretProvider) GetIAMTokenContext(ctx context.Context, secret string, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	return fsp.getToken(ctx, func() (string, uint64, error) {
+		return fsp.managed.GetIAMTokenContext(ctx, secret, freshTokenRequired, reasonForCall...)
+	}, func() (string, uint64, error) {
+		return fsp.unmanaged.GetIAMTokenContext(ctx, secret, freshTokenRequired, reasonForCall...)
+	})
+}
+
+// GetIAMTokenForCredential is served by the managed secret provider, which fetches the token without the sidecar.
+func (fsp *FailoverSecretProvider) GetIAMTokenForCredential(ctx context.Context, credential Credential, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	return fsp.managed.GetIAMTokenForCredential(ctx, credential, freshTokenRequired, reasonForCall...)
+}
+
+// GetRIAASEndpoint ...
+func (fsp *FailoverSecretProvider) GetRIAASEndpoint(readConfig bool) (string, error) {
+	return fsp.managed.GetRIAASEndpoint(readConfig)
+}
+
+// GetRIAASEndpointContext ...
+func (fsp *FailoverSecretProvider) GetRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error) {
+	return fsp.managed.GetRIAASEndpointContext(ctx, readConfig)
+}
+
+// GetPrivateRIAASEndpoint ...
+func (fsp *FailoverSecretProvider) GetPrivateRIAASEndpoint(readConfig bool) (string, error) {
+	return fsp.managed.GetPrivateRIAASEndpoint(readConfig)
+}
+
+// GetPrivateRIAASEndpointContext ...
+func (fsp *FailoverSecretProvider) GetPrivateRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error) {
+	return fsp.managed.GetPrivateRIAASEndpointContext(ctx, readConfig)
+}
+
+// GetContainerAPIRoute ...
+func (fsp *FailoverSecretProvider) GetContainerAPIRoute(readConfig bool) (string, error) {
+	return fsp.managed.GetContainerAPIRoute(readConfig)
+}
+
+// GetContainerAPIRouteContext ...
+func (fsp *FailoverSecretProvider) GetContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error) {
+	return fsp.managed.GetContainerAPIRouteContext(ctx, readConfig)
+}
+
+// GetPrivateContainerAPIRoute ...
+func (fsp *FailoverSecretProvider) GetPrivateContainerAPIRoute(readConfig bool) (string, error) {
+	return fsp.managed.GetPrivateContainerAPIRoute(readConfig)
+}
+
+// GetPrivateContainerAPIRouteContext ...
+func (fsp *FailoverSecretProvider) GetPrivateContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error) {
+	return fsp.managed.GetPrivateContainerAPIRouteContext(ctx, readConfig)
+}
+
+// GetEndpoint ...
+func (fsp *FailoverSecretProvider) GetEndpoint(ctx context.Context, name string, refresh bool) (string, error) {
+	return fsp.managed.GetEndpoint(ctx, name, refresh)
+}
+
+// ListEndpoints ...
+func (fsp *FailoverSecretProvider) ListEndpoints() []string {
+	return fsp.managed.ListEndpoints()
+}
+
+// GetEndpointSource ...
+func (fsp *FailoverSecretProvider) GetEndpointSource(name string) (EndpointSource, error) {
+	return fsp.managed.GetEndpointSource(name)
+}
+
+// DescribeEndpoints ...
+func (fsp *FailoverSecretProvider) DescribeEndpoints() []EndpointInfo {
+	return fsp.managed.DescribeEndpoints()
+}
+
+// GetRegion ...
+func (fsp *FailoverSecretProvider) GetRegion() string {
+	return fsp.managed.GetRegion()
+}
+
+// GetTokenExchangeURL ...
+func (fsp *FailoverSecretProvider) GetTokenExchangeURL() string {
+	return fsp.managed.GetTokenExchangeURL()
+}
+
+// IsPrivateTokenExchange ...
+func (fsp *FailoverSecretProvider) IsPrivateTokenExchange() bool {
+	return fsp.managed.IsPrivateTokenExchange()
+}
+
+// GetResourceGroupID ...
+func (fsp *FailoverSecretProvider) GetResourceGroupID() string {
+	return fsp.managed.GetResourceGroupID()
+}
+
+// Subscribe registers the given callback for the events of both managed and unmanaged secret providers.
+func (fsp *FailoverSecretProvider) Subscribe(callback func(Event)) func() {
+	unsubscribeManaged := fsp.managed.Subscribe(callback)
+	if fsp.unmanaged == nil {
+		return unsubscribeManaged
+	}
+
+	unsubscribeUnmanaged := fsp.unmanaged.Subscribe(callback)
+	return func() {
+		unsubscribeManaged()
+		unsubscribeUnmanaged()
+	}
+}
+
+// Healthy returns nil if the sidecar is serving.
+func (fsp *FailoverSecretProvider) Healthy(ctx context.Context) error {
+	return fsp.managed.Healthy(ctx)
+}
+
+// HealthStatus returns the result of the last health check of the sidecar.
+func (fsp *FailoverSecretProvider) HealthStatus() error {
+	return fsp.managed.HealthStatus()
+}
+
+// Close stops the background health checker and token refresher, and closes the connection to sidecar.
+func (fsp *FailoverSecretProvider) Close() error {
+	if fsp.unmanaged != nil {
+		_ = fsp.unmanaged.Close()
+	}
+	return fsp.managed.Close()
+}
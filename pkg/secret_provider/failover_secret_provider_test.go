@@ -0,0 +1,172 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	secret_provider "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	"github.com/IBM/secret-common-lib/pkg/secret_provider/secretsidecartest"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// retryInterval is the time after which the failover secret provider tries the sidecar again.
+	retryInterval = 300 * time.Millisecond
+)
+
+// newTestFailoverSecretProvider starts a fake sidecar and a fake IAM server, and initialises a failover secret provider
+// using them. The sidecar is set up by the given function before the secret provider is initialised.
+func newTestFailoverSecretProvider(t *testing.T, setup func(server *secretsidecartest.Server)) (*secret_provider.FailoverSecretProvider, *secretsidecartest.Server, *secret_provider.FakeIAMServer) {
+	t.Helper()
+	server, err := secretsidecartest.NewServer()
+	if err != nil {
+		t.Fatalf("Unable to start fake sidecar: %v", err)
+	}
+	t.Cleanup(server.Close)
+	if setup != nil {
+		setup(server)
+	}
+
+	iamServer := secret_provider.NewFakeIAMServer(t)
+	k8sClient := secret_provider.NewFakeK8sClientWithIAM(t, iamServer.URL)
+	opts := append(server.Options(), secret_provider.WithLogger(zap.NewNop()), secret_provider.WithUnmanagedFallback(retryInterval))
+	provider, err := secret_provider.NewSecretProviderWithOptions(&k8sClient, opts...)
+	if err != nil {
+		t.Fatalf("Unable to initialise failover secret provider: %v", err)
+	}
+	fsp, ok := provider.(*secret_provider.FailoverSecretProvider)
+	if !ok {
+		t.Fatalf("Expected failover secret provider, got %T", provider)
+	}
+	t.Cleanup(func() { _ = fsp.Close() })
+	return fsp, server, iamServer
+}
+
+// sidecarTokenCalls returns the number of GetDefaultIAMToken calls received by the fake sidecar.
+func sidecarTokenCalls(server *secretsidecartest.Server) int {
+	count := 0
+	for _, request := range server.Requests() {
+		if request.Method == secretsidecartest.MethodGetDefaultIAMToken {
+			count++
+		}
+	}
+	return count
+}
+
+// expectSidecarToken checks that the token was fetched by the sidecar.
+func expectSidecarToken(t *testing.T, fsp *secret_provider.FailoverSecretProvider, server *secretsidecartest.Server) {
+	t.Helper()
+	calls := sidecarTokenCalls(server)
+	token, _, err := fsp.GetDefaultIAMToken(true)
+	if err != nil {
+		t.Fatalf("Unable to fetch token: %v", err)
+	}
+	if token != secretsidecartest.DefaultIAMToken || sidecarTokenCalls(server) != calls+1 {
+		t.Errorf("Expected the token of the sidecar, got %q", token)
+	}
+}
+
+// expectUnmanagedToken checks that the token was fetched from IAM by the unmanaged secret provider, without calling the sidecar.
+func expectUnmanagedToken(t *testing.T, fsp *secret_provider.FailoverSecretProvider, server *secretsidecartest.Server, iamServer *secret_provider.FakeIAMServer) {
+	t.Helper()
+	calls, iamRequests := sidecarTokenCalls(server), iamServer.RequestCount()
+	token, _, err := fsp.GetDefaultIAMToken(true)
+	if err != nil {
+		t.Fatalf("Unable to fetch token: %v", err)
+	}
+	if token == secretsidecartest.DefaultIAMToken || iamServer.RequestCount() != iamRequests+1 {
+		t.Errorf("Expected the token of the unmanaged secret provider, got %q", token)
+	}
+	if sidecarTokenCalls(server) != calls {
+		t.Error("Expected the sidecar not to be called")
+	}
+}
+
+func TestFailoverFallBackAndRecover(t *testing.T) {
+	fsp, server, iamServer := newTestFailoverSecretProvider(t, nil)
+	expectSidecarToken(t, fsp, server)
+
+	// The call failing with Unavailable is served by the unmanaged secret provider, and so are the calls within the retry interval
+	server.SetDefaultResponse(secretsidecartest.Response{Err: status.Error(codes.Unavailable, "sidecar restarting")})
+	calls := sidecarTokenCalls(server)
+	token, _, err := fsp.GetDefaultIAMToken(true)
+	if err != nil || token == secretsidecartest.DefaultIAMToken {
+		t.Fatalf("Expected the call to fall back to the unmanaged secret provider, got %q, %v", token, err)
+	}
+	if sidecarTokenCalls(server) == calls {
+		t.Error("Expected the sidecar to be called first")
+	}
+	expectUnmanagedToken(t, fsp, server, iamServer)
+
+	// Once the retry interval passes, the sidecar is probed and the calls are routed to it
+	server.SetDefaultResponse(secretsidecartest.Response{Token: secretsidecartest.DefaultIAMToken, TokenLifetime: secretsidecartest.DefaultTokenLifetime})
+	time.Sleep(retryInterval)
+	expectSidecarToken(t, fsp, server)
+	expectSidecarToken(t, fsp, server)
+}
+
+func TestFailoverSidecarNotServing(t *testing.T) {
+	fsp, server, iamServer := newTestFailoverSecretProvider(t, func(server *secretsidecartest.Server) {
+		server.SetServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	})
+
+	// The sidecar failed the probe while initialising, hence it is not initialised either
+	expectUnmanagedToken(t, fsp, server, iamServer)
+	for _, request := range server.Requests() {
+		if request.Method == secretsidecartest.MethodNewSecretProvider {
+			t.Error("Expected the sidecar not to be initialised")
+		}
+	}
+
+	// The probe fails again once the retry interval passes
+	time.Sleep(retryInterval)
+	expectUnmanagedToken(t, fsp, server, iamServer)
+
+	server.SetServingStatus(healthpb.HealthCheckResponse_SERVING)
+	time.Sleep(retryInterval)
+	expectSidecarToken(t, fsp, server)
+}
+
+func TestFailoverSidecarMissing(t *testing.T) {
+	fsp, server, iamServer := newTestFailoverSecretProvider(t, func(server *secretsidecartest.Server) {
+		server.Close()
+	})
+	expectUnmanagedToken(t, fsp, server, iamServer)
+}
+
+func TestFailoverCallerCancelled(t *testing.T) {
+	fsp, server, _ := newTestFailoverSecretProvider(t, nil)
+	expectSidecarToken(t, fsp, server)
+
+	// The call cancelled by its caller does not change the routing, even though the sidecar did not respond in time
+	server.SetDefaultResponse(secretsidecartest.Response{Token: secretsidecartest.DefaultIAMToken, TokenLifetime: secretsidecartest.DefaultTokenLifetime, Latency: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := fsp.GetDefaultIAMTokenContext(ctx, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	server.SetDefaultResponse(secretsidecartest.Response{Token: secretsidecartest.DefaultIAMToken, TokenLifetime: secretsidecartest.DefaultTokenLifetime})
+	expectSidecarToken(t, fsp, server)
+}
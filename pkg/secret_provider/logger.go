@@ -0,0 +1,137 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// logLevelEnv is the environment variable which can hold the log level - debug, info, warn, error.
+	logLevelEnv = "SECRET_PROVIDER_LOG_LEVEL"
+)
+
+// getLogLevel returns the log level set in the environment, if it is not set or invalid, info level is returned.
+func getLogLevel() zapcore.Level {
+	level := zap.InfoLevel
+	if logLevel := os.Getenv(logLevelEnv); logLevel != "" {
+		_ = level.UnmarshalText([]byte(logLevel))
+	}
+	return level
+}
+
+// loggerLevel returns the minimum level enabled by the given logger.
+func loggerLevel(logger *zap.Logger) zapcore.Level {
+	for level := zapcore.DebugLevel; level < zapcore.FatalLevel; level++ {
+		if logger.Core().Enabled(level) {
+			return level
+		}
+	}
+	return zapcore.FatalLevel
+}
+
+// newLogrLogger returns a zap logger which writes to the given logr logger.
+func newLogrLogger(logger logr.Logger) *zap.Logger {
+	return zap.New(&logrCore{logger: logger}, zap.AddCaller())
+}
+
+// logrCore is a zapcore.Core which writes the entries to a logr logger.
+// Debug entries are logged at V(1), error entries are logged using Error and the rest at V(0).
+type logrCore struct {
+	logger logr.Logger
+	fields []zapcore.Field
+}
+
+// Enabled ...
+func (lc *logrCore) Enabled(level zapcore.Level) bool {
+	if level >= zapcore.ErrorLevel {
+		return true
+	}
+	return lc.logger.V(logrVerbosity(level)).Enabled()
+}
+
+// With ...
+func (lc *logrCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &logrCore{logger: lc.logger}
+	clone.fields = append(clone.fields, lc.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return clone
+}
+
+// Check ...
+func (lc *logrCore) Check(entry zapcore.Entry, checkedEntry *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if lc.Enabled(entry.Level) {
+		return checkedEntry.AddCore(entry, lc)
+	}
+	return checkedEntry
+}
+
+// Write ...
+func (lc *logrCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range lc.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	keys := make([]string, 0, len(enc.Fields))
+	for key := range enc.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var err error
+	keysAndValues := make([]interface{}, 0, 2*len(keys))
+	for _, key := range keys {
+		if errMsg, ok := enc.Fields[key].(string); ok && key == "error" {
+			err = errors.New(errMsg)
+			continue
+		}
+		keysAndValues = append(keysAndValues, key, enc.Fields[key])
+	}
+
+	if entry.Level >= zapcore.ErrorLevel {
+		lc.logger.Error(err, entry.Message, keysAndValues...)
+		return nil
+	}
+	if err != nil {
+		keysAndValues = append(keysAndValues, "error", err.Error())
+	}
+	lc.logger.V(logrVerbosity(entry.Level)).Info(entry.Message, keysAndValues...)
+	return nil
+}
+
+// Sync ...
+func (lc *logrCore) Sync() error {
+	return nil
+}
+
+// logrVerbosity ...
+func logrVerbosity(level zapcore.Level) int {
+	if level < zapcore.InfoLevel {
+		return 1
+	}
+	return 0
+}
@@ -20,264 +20,506 @@ import (
 	"context"
 	"flag"
 	"net"
+	"sync"
 	"time"
 
 	localutils "github.com/IBM/secret-common-lib/pkg/utils"
-	"github.com/IBM/secret-utils-lib/pkg/config"
 	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
 	"github.com/IBM/secret-utils-lib/pkg/utils"
 	sp "github.com/IBM/secret-utils-lib/secretprovider"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
 var (
 	endpoint = flag.String("sidecarEndpoint", "/csi/provider.sock", "Storage secret sidecar endpoint")
 )
 
+const (
+	// sidecarCallTimeout is the timeout applied on the calls to sidecar when the caller does not provide a context.
+	sidecarCallTimeout = 5 * time.Minute
+
+	// sidecarKeepaliveTime is kept same as the minimum ping interval permitted by the grpc server by default.
+	sidecarKeepaliveTime = 5 * time.Minute
+
+	// sidecarKeepaliveTimeout ...
+	sidecarKeepaliveTimeout = 20 * time.Second
+
+	// sidecarReconnectMaxDelay is the maximum backoff between attempts to reconnect to the sidecar.
+	sidecarReconnectMaxDelay = 10 * time.Second
+)
+
 // ManagedSecretProvider ...
 type ManagedSecretProvider struct {
-	logger                   *zap.Logger
-	k8sClient                k8s_utils.KubernetesClient
-	region                   string
-	riaasEndpoint            string
-	privateRIAASEndpoint     string
-	containerAPIRoute        string
-	privateContainerAPIRoute string
-	resourceGroupID          string
+	logger          *zap.Logger
+	k8sClient       k8s_utils.KubernetesClient
+	region          string
+	endpoints       *endpointRegistry
+	resourceGroupID string
+	endpoint        string
+	retryPolicy     sidecarRetryPolicy
+	providerType    string
+	tokenExpiryDiff time.Duration
+
+	// secretCache holds the authenticators and tokens of the credentials provided in GetIAMTokenForCredential, whose
+	// tokens are fetched without the sidecar using the token exchange URL. The token exchange URL is read from the config
+	// on the first call to GetTokenExchangeURL or GetIAMTokenForCredential, since the sidecar does not provide it.
+	secretCache              *secretCache
+	tokenExchangeURL         string
+	providedTokenExchangeURL bool
+
+	// configMutex guards the token exchange URL, region and resource group ID, which are replaced by the config watcher
+	configMutex sync.RWMutex
+
+	// conn is the connection to sidecar, shared by all the calls and re-established automatically by grpc.
+	conn      *grpc.ClientConn
+	connMutex sync.Mutex
+
+	// tokenFlight coalesces the concurrent calls to sidecar for the token of the same secret
+	tokenFlight tokenFlight
+
+	// healthChecker is set if the background health checker is running
+	healthChecker *healthChecker
+
+	subscribers subscribers
 }
 
 // newManagedSecretProvider makes a call to storage-secret-sidecar to initialise the secret provider.
-// argument1: logger
-// argument2: optionalArgs which can hold the providerType which is VPC/Bluemix/Softlayer. Currently, VPC/Bluemix is supported.
-func newManagedSecretProvider(logger *zap.Logger, optionalArgs ...string) (*ManagedSecretProvider, error) {
-	logger.Info("Connecting to sidecar")
-	kc, err := k8s_utils.Getk8sClientSet()
+// argument1: k8sClient, if it is not provided, the in cluster k8s client is used.
+// argument2: logger
+// argument3: opts which can hold the providerType which is VPC/Bluemix/Softlayer. Currently, VPC/Bluemix is supported.
+func newManagedSecretProvider(k8sClient *k8s_utils.KubernetesClient, logger *zap.Logger, opts *providerOptions) (*ManagedSecretProvider, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
+	defer cancel()
+
+	msp, err := initManagedSecretProvider(ctx, k8sClient, logger, opts)
 	if err != nil {
-		logger.Info("Error fetching k8s client set", zap.Error(err))
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
-
-	// Connecting to sidecar
-	conn, err := grpc.DialContext(ctx, *endpoint, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(unixConnect))
-	defer conn.Close()
+	err = msp.initSidecar(ctx, opts.providerType)
 	if err != nil {
-		logger.Error("Error establishing grpc connection to secret sidecar", zap.Error(err))
-		return nil, utils.Error{Description: "Error establishing grpc connection", BackendError: err.Error()}
+		logger.Error("Error initiliazing managed secret provider", zap.Error(err))
+		_ = msp.Close()
+		return nil, err
 	}
 
-	// If any providerType - vpc, bluemix, softlayer is provided, then make a call to sidecar
-	// If it is not provided, no need to make a call to sidecar, on first GetDefaultIAMToken call, secret provider will be initialised
-	if len(optionalArgs) != 0 {
-		c := sp.NewSecretProviderClient(conn)
-		// NewSecretProvider call to sidecar
-		_, err = c.NewSecretProvider(ctx, &sp.InitRequest{ProviderType: optionalArgs[0]})
-		if err != nil {
-			logger.Error("Error initiliazing managed secret provider", zap.Error(err))
-			return nil, err
-		}
+	if opts.healthCheckInterval > 0 {
+		msp.startHealthChecker(opts.healthCheckInterval)
+	}
+	if opts.configWatcher {
+		msp.endpoints.startWatcher(msp.k8sClient, msp.logger, msp.subscribers.publishEndpointChange, msp.reloadConfig)
+	}
+
+	logger.Info("Initialized managed secret provider")
+	return msp, nil
+}
+
+// initManagedSecretProvider initialises the managed secret provider and reads the endpoints, without connecting to sidecar.
+// The config is read using the in cluster k8s client if the secret provider is initialised by NewSecretProvider, or the
+// given client is nil or not initialised, else using the given client, same as the unmanaged secret provider.
+func initManagedSecretProvider(ctx context.Context, k8sClient *k8s_utils.KubernetesClient, logger *zap.Logger, opts *providerOptions) (*ManagedSecretProvider, error) {
+	var kc k8s_utils.KubernetesClient
+	var err error
+	if !opts.inClusterClient && k8sClient != nil && k8sClient.Clientset != nil {
+		kc = *k8sClient
+	} else if kc, err = k8s_utils.Getk8sClientSet(); err != nil {
+		logger.Info("Error fetching k8s client set", zap.Error(err))
+		return nil, err
+	}
+	if opts.namespace != "" {
+		kc.Namespace = opts.namespace
 	}
 
+	msp := &ManagedSecretProvider{logger: logger, k8sClient: kc, endpoint: opts.sidecarEndpoint, retryPolicy: opts.sidecarRetry, providerType: opts.providerType}
+	msp.endpoints = newEndpointRegistry(opts.endpoints, opts.regionTemplates, opts.endpointValidation, opts.endpointFallback)
+	msp.tokenExpiryDiff = getTokenExpiryDiff(logger, opts)
+	msp.secretCache = newSecretCache(getSecretCacheLimit(logger, opts))
+
 	// Reading endpoints
-	msp := &ManagedSecretProvider{logger: logger, k8sClient: kc}
-	err = msp.initEndpointsUsingCloudConf()
+	err = msp.initEndpointsUsingCloudConf(ctx)
 	if err == nil {
-		logger.Info("Initialized managed secret provider")
 		return msp, nil
 	}
 
 	logger.Info("Unable to fetch endpoints from cloud-conf", zap.Error(err))
-	err = msp.initEndpointsUsingStorageSecretStore()
+	err = msp.initEndpointsUsingStorageSecretStore(ctx)
 	if err != nil {
 		// Do not return even if there is an error reading endpoints, just logging error
 		logger.Warn("Unable to fetch endpoints from storage-secret-store", zap.Error(err))
 	}
-
-	logger.Info("Initialized managed secret provider")
 	return msp, nil
 }
 
-// GetDefaultIAMToken ...
-func (msp *ManagedSecretProvider) GetDefaultIAMToken(freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
-	var tokenlifetime uint64
-	// Connecting to sidecar
+// initSidecar connects to sidecar, and if any providerType - vpc, bluemix, softlayer is provided, makes a call to sidecar to initialise the secret provider.
+// If it is not provided, no need to make a call to sidecar, on first GetDefaultIAMToken call, secret provider will be initialised
+func (msp *ManagedSecretProvider) initSidecar(ctx context.Context, providerType string) error {
 	msp.logger.Info("Connecting to sidecar")
-	conn, err := grpc.Dial(*endpoint, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(unixConnect))
-	if err != nil {
-		msp.logger.Error("Error establishing grpc connection to secret sidecar", zap.Error(err))
-		return "", tokenlifetime, utils.Error{Description: "Error establishing grpc connection to secret sidecar", BackendError: err.Error()}
-	}
+	return msp.callSidecar(ctx, func(ctx context.Context, c sp.SecretProviderClient) error {
+		if providerType == "" {
+			return nil
+		}
+		// NewSecretProvider call to sidecar
+		_, err := c.NewSecretProvider(ctx, &sp.InitRequest{ProviderType: providerType})
+		return err
+	})
+}
 
-	c := sp.NewSecretProviderClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+// GetDefaultIAMToken ...
+func (msp *ManagedSecretProvider) GetDefaultIAMToken(freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
 	defer cancel()
-	defer conn.Close()
+	return msp.GetDefaultIAMTokenContext(ctx, freshTokenRequired, reasonForCall...)
+}
 
+// GetDefaultIAMTokenContext is same as GetDefaultIAMToken, the connection to sidecar and the call are bound to ctx.
+func (msp *ManagedSecretProvider) GetDefaultIAMTokenContext(ctx context.Context, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
 	tokenReq := new(sp.Request)
 	tokenReq.IsFreshTokenRequired = freshTokenRequired
 	if len(reasonForCall) != 0 {
 		tokenReq.ReasonForCall = reasonForCall[0]
 	}
-	response, err := c.GetDefaultIAMToken(ctx, tokenReq)
+
+	// The concurrent callers share a single call to sidecar, made with the reason of the first caller
+	token, tokenlifetime, err := msp.tokenFlight.do(ctx, flightKey(defaultFlightKey, freshTokenRequired), func(ctx context.Context) (string, uint64, error) {
+		var response *sp.IAMToken
+		msp.logger.Debug("Connecting to sidecar")
+		err := msp.callSidecar(ctx, func(ctx context.Context, c sp.SecretProviderClient) error {
+			var err error
+			response, err = c.GetDefaultIAMToken(ctx, tokenReq)
+			return err
+		})
+		if err != nil {
+			return "", 0, sidecarError(err)
+		}
+		return response.Iamtoken, response.Tokenlifetime, nil
+	})
 	if err != nil {
 		msp.logger.Error("Error fetching IAM token", zap.Error(err))
 		return "", tokenlifetime, err
 	}
 
-	msp.logger.Info("Fetched IAM token for default secret")
-	return response.Iamtoken, response.Tokenlifetime, nil
+	msp.logger.Debug("Fetched IAM token for default secret")
+	return token, tokenlifetime, nil
 }
 
 // GetIAMToken ...
 func (msp *ManagedSecretProvider) GetIAMToken(secret string, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
-	var tokenlifetime uint64
-
-	msp.logger.Info("Connecting to sidecar")
-	conn, err := grpc.Dial(*endpoint, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(unixConnect))
-	if err != nil {
-		msp.logger.Error("Error establishing grpc connection to secret sidecar", zap.Error(err))
-		return "", tokenlifetime, utils.Error{Description: "Error establishing grpc connection to secret sidecar", BackendError: err.Error()}
-	}
-
-	c := sp.NewSecretProviderClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
 	defer cancel()
-	defer conn.Close()
+	return msp.GetIAMTokenContext(ctx, secret, freshTokenRequired, reasonForCall...)
+}
 
+// GetIAMTokenContext is same as GetIAMToken, the connection to sidecar and the call are bound to ctx.
+func (msp *ManagedSecretProvider) GetIAMTokenContext(ctx context.Context, secret string, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
 	tokenReq := new(sp.Request)
 	tokenReq.IsFreshTokenRequired = freshTokenRequired
 	tokenReq.Secret = secret
 	if len(reasonForCall) != 0 {
 		tokenReq.ReasonForCall = reasonForCall[0]
 	}
-	response, err := c.GetIAMToken(ctx, tokenReq)
+
+	// The concurrent callers of the same secret share a single call to sidecar, made with the reason of the first caller
+	token, tokenlifetime, err := msp.tokenFlight.do(ctx, flightKey(hashSecret(secret), freshTokenRequired), func(ctx context.Context) (string, uint64, error) {
+		var response *sp.IAMToken
+		msp.logger.Debug("Connecting to sidecar")
+		err := msp.callSidecar(ctx, func(ctx context.Context, c sp.SecretProviderClient) error {
+			var err error
+			response, err = c.GetIAMToken(ctx, tokenReq)
+			return err
+		})
+		if err != nil {
+			return "", 0, sidecarError(err)
+		}
+		return response.Iamtoken, response.Tokenlifetime, nil
+	})
 	if err != nil {
 		msp.logger.Error("Error fetching IAM token", zap.Error(err))
 		return "", tokenlifetime, err
 	}
 
-	msp.logger.Info("Fetched IAM token for the provided secret")
-	return response.Iamtoken, response.Tokenlifetime, nil
+	msp.logger.Debug("Fetched IAM token for the provided secret")
+	return token, tokenlifetime, nil
 }
 
-// unixConnect ...
-func unixConnect(ctx context.Context, addr string) (net.Conn, error) {
-	unixAddr, err := net.ResolveUnixAddr("unix", addr)
+// GetIAMTokenForCredential returns the token of the given credential, which is fetched without the sidecar and cached,
+// same as the unmanaged secret provider.
+func (msp *ManagedSecretProvider) GetIAMTokenForCredential(ctx context.Context, credential Credential, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	msp.logger.Debug("In GetIAMTokenForCredential()", zap.String("credential-type", string(credential.Type)))
+	if err := credential.validate(); err != nil {
+		return "", 0, err
+	}
+
+	tokenExchangeURL, providedTokenExchangeURL := msp.getTokenExchangeURL(ctx)
+	entry := msp.secretCache.get(credential.cacheKey(), string(credential.Type), func() tokenFetcher {
+		return credential.newAuthenticator(msp.logger, tokenExchangeURL, providedTokenExchangeURL)
+	})
+
+	token, tokenlifetime, err := entry.getToken(ctx, freshTokenRequired, msp.tokenExpiryDiff)
 	if err != nil {
-		return nil, err
+		msp.logger.Error("Error fetching IAM token for the provided credential", zap.Error(err))
+		return token, tokenlifetime, err
 	}
-	conn, err := net.DialUnix("unix", nil, unixAddr)
-	return conn, err
+	return token, tokenlifetime, nil
 }
 
-// GetRIAASEndpoint ...
-func (msp *ManagedSecretProvider) GetRIAASEndpoint(readConfig bool) (string, error) {
-	msp.logger.Info("In GetRIAASEndpoint()")
-	if !readConfig {
-		msp.logger.Info("Returning RIAAS endpoint", zap.String("Endpoint", msp.riaasEndpoint))
-		return msp.riaasEndpoint, nil
+// getTokenExchangeURL returns the token exchange URL, reading it on the first call.
+func (msp *ManagedSecretProvider) getTokenExchangeURL(ctx context.Context) (string, bool) {
+	msp.configMutex.RLock()
+	tokenExchangeURL, providedTokenExchangeURL := msp.tokenExchangeURL, msp.providedTokenExchangeURL
+	msp.configMutex.RUnlock()
+	if tokenExchangeURL != "" {
+		return tokenExchangeURL, providedTokenExchangeURL
+	}
+
+	// The config is read without holding configMutex, so that reading the region and resource group ID is not blocked
+	tokenExchangeURL, providedTokenExchangeURL = getTokenExchangeURL(ctx, msp.logger, msp.k8sClient, msp.providerType)
+
+	msp.configMutex.Lock()
+	defer msp.configMutex.Unlock()
+	if msp.tokenExchangeURL == "" {
+		msp.tokenExchangeURL, msp.providedTokenExchangeURL = tokenExchangeURL, providedTokenExchangeURL
 	}
+	return msp.tokenExchangeURL, msp.providedTokenExchangeURL
+}
 
-	endpoint, err := getEndpoint(localutils.RIAAS, msp.riaasEndpoint, msp.k8sClient, msp.logger)
+// reloadConfig replaces the region, resource group ID and token exchange URL with the ones read by the config watcher.
+// The ones which cannot be read are kept.
+func (msp *ManagedSecretProvider) reloadConfig(reader configReader) {
+	region, resourceGroupID, tokenExchangeURL, err := readConfig(context.Background(), reader)
 	if err != nil {
-		return "", err
+		msp.logger.Warn("Unable to read config, keeping the previous region, resource group ID and token exchange URL", zap.Error(err))
+		return
 	}
 
-	msp.riaasEndpoint = endpoint
-	return endpoint, nil
+	msp.configMutex.Lock()
+	if region != "" {
+		msp.region = region
+	}
+	if resourceGroupID != "" {
+		msp.resourceGroupID = resourceGroupID
+	}
+	tokenExchangeURLChanged := tokenExchangeURL != "" && tokenExchangeURL != msp.tokenExchangeURL
+	if tokenExchangeURLChanged {
+		msp.tokenExchangeURL, msp.providedTokenExchangeURL = tokenExchangeURL, true
+	}
+	msp.configMutex.Unlock()
+
+	if tokenExchangeURLChanged {
+		msp.logger.Info("Token exchange URL changed", zap.String("url", tokenExchangeURL))
+		// The authenticators of the credentials are created again, using the token exchange URL
+		msp.secretCache.clear()
+	}
 }
 
-// GetPrivateRIAASEndpoint ...
-func (msp *ManagedSecretProvider) GetPrivateRIAASEndpoint(readConfig bool) (string, error) {
-	msp.logger.Info("In GetPrivateRIAASEndpoint()")
-	if !readConfig {
-		msp.logger.Info("Returning private RIAAS endpoint", zap.String("Endpoint", msp.privateRIAASEndpoint))
-		return msp.privateRIAASEndpoint, nil
+// getClient returns the client using the connection to sidecar, the connection is created on the first call.
+// The connection is not blocked on, the calls wait for the connection to be ready until their context is done.
+func (msp *ManagedSecretProvider) getClient() (sp.SecretProviderClient, error) {
+	msp.connMutex.Lock()
+	defer msp.connMutex.Unlock()
+
+	if msp.conn != nil {
+		return sp.NewSecretProviderClient(msp.conn), nil
 	}
 
-	endpoint, err := getEndpoint(localutils.PrivateRIAAS, msp.privateRIAASEndpoint, msp.k8sClient, msp.logger)
+	reconnectBackoff := backoff.DefaultConfig
+	reconnectBackoff.MaxDelay = sidecarReconnectMaxDelay
+	conn, err := grpc.Dial(msp.endpoint,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(unixConnect),
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: reconnectBackoff}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: sidecarKeepaliveTime, Timeout: sidecarKeepaliveTimeout}))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	msp.privateRIAASEndpoint = endpoint
-	return endpoint, nil
+	msp.conn = conn
+	return sp.NewSecretProviderClient(conn), nil
 }
 
-// GetContainerAPIRoute ...
-func (msp *ManagedSecretProvider) GetContainerAPIRoute(readConfig bool) (string, error) {
-	msp.logger.Info("In GetContainerAPIRoute()")
-	if !readConfig {
-		msp.logger.Info("Returning container api route", zap.String("Endpoint", msp.containerAPIRoute))
-		return msp.containerAPIRoute, nil
+// isConnected returns true if the connection to sidecar is ready, without waiting for it.
+func (msp *ManagedSecretProvider) isConnected() bool {
+	msp.connMutex.Lock()
+	defer msp.connMutex.Unlock()
+	return msp.conn != nil && msp.conn.GetState() == connectivity.Ready
+}
+
+// waitForConnection blocks until the connection to sidecar is ready or ctx is done.
+func (msp *ManagedSecretProvider) waitForConnection(ctx context.Context) error {
+	msp.connMutex.Lock()
+	conn := msp.conn
+	msp.connMutex.Unlock()
+	if conn == nil {
+		return utils.Error{Description: localutils.ErrSidecarConnectionClosed}
 	}
 
-	endpoint, err := getEndpoint(localutils.ContainerAPIRoute, msp.containerAPIRoute, msp.k8sClient, msp.logger)
-	if err != nil {
-		return "", err
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
 	}
+}
 
-	msp.containerAPIRoute = endpoint
-	return endpoint, nil
+// Close stops the background health checker and closes the connection to sidecar, a new connection is created if the secret provider is used thereafter.
+func (msp *ManagedSecretProvider) Close() error {
+	msp.stopHealthChecker()
+	msp.endpoints.stopWatcher()
+
+	msp.connMutex.Lock()
+	defer msp.connMutex.Unlock()
+
+	if msp.conn == nil {
+		return nil
+	}
+
+	err := msp.conn.Close()
+	msp.conn = nil
+	return err
+}
+
+// unixConnect dials the unix socket of sidecar, returning as soon as ctx is done.
+func unixConnect(ctx context.Context, addr string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+}
+
+// GetRIAASEndpoint ...
+func (msp *ManagedSecretProvider) GetRIAASEndpoint(readConfig bool) (string, error) {
+	return msp.GetRIAASEndpointContext(context.Background(), readConfig)
+}
+
+// GetRIAASEndpointContext is same as GetRIAASEndpoint, reading the config is bound to ctx.
+func (msp *ManagedSecretProvider) GetRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error) {
+	msp.logger.Debug("In GetRIAASEndpoint()")
+	return msp.GetEndpoint(ctx, localutils.RIAAS, readConfig)
+}
+
+// GetPrivateRIAASEndpoint ...
+func (msp *ManagedSecretProvider) GetPrivateRIAASEndpoint(readConfig bool) (string, error) {
+	return msp.GetPrivateRIAASEndpointContext(context.Background(), readConfig)
+}
+
+// GetPrivateRIAASEndpointContext is same as GetPrivateRIAASEndpoint, reading the config is bound to ctx.
+func (msp *ManagedSecretProvider) GetPrivateRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error) {
+	msp.logger.Debug("In GetPrivateRIAASEndpoint()")
+	return msp.GetEndpoint(ctx, localutils.PrivateRIAAS, readConfig)
+}
+
+// GetContainerAPIRoute ...
+func (msp *ManagedSecretProvider) GetContainerAPIRoute(readConfig bool) (string, error) {
+	return msp.GetContainerAPIRouteContext(context.Background(), readConfig)
+}
+
+// GetContainerAPIRouteContext is same as GetContainerAPIRoute, reading the config is bound to ctx.
+func (msp *ManagedSecretProvider) GetContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error) {
+	msp.logger.Debug("In GetContainerAPIRoute()")
+	return msp.GetEndpoint(ctx, localutils.ContainerAPIRoute, readConfig)
 }
 
 // GetPrivateContainerAPIRoute ...
 func (msp *ManagedSecretProvider) GetPrivateContainerAPIRoute(readConfig bool) (string, error) {
-	msp.logger.Info("In GetPrivateContainerAPIRoute()")
-	if !readConfig {
-		msp.logger.Info("Returning private container api route", zap.String("Endpoint", msp.privateContainerAPIRoute))
-		return msp.privateContainerAPIRoute, nil
-	}
+	return msp.GetPrivateContainerAPIRouteContext(context.Background(), readConfig)
+}
+
+// GetPrivateContainerAPIRouteContext is same as GetPrivateContainerAPIRoute, reading the config is bound to ctx.
+func (msp *ManagedSecretProvider) GetPrivateContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error) {
+	msp.logger.Debug("In GetPrivateContainerAPIRoute()")
+	return msp.GetEndpoint(ctx, localutils.PrivateContainerAPIRoute, readConfig)
+}
 
-	endpoint, err := getEndpoint(localutils.PrivateContainerAPIRoute, msp.privateContainerAPIRoute, msp.k8sClient, msp.logger)
+// GetEndpoint returns the endpoint of the given name, read from the config if refresh is true. The endpoints are
+// registered using WithEndpoint, along with the built in RIAAS, Private-RIAAS, Container-API-Route and Private-Container-API-Route.
+func (msp *ManagedSecretProvider) GetEndpoint(ctx context.Context, name string, refresh bool) (string, error) {
+	endpoint, previous, err := msp.endpoints.getEndpoint(ctx, name, refresh, msp.k8sClient, msp.logger)
 	if err != nil {
 		return "", err
 	}
 
-	msp.privateContainerAPIRoute = endpoint
+	msp.subscribers.publishEndpointChange(name, previous, endpoint)
 	return endpoint, nil
 }
 
+// ListEndpoints returns the names of the registered endpoints, in sorted order.
+func (msp *ManagedSecretProvider) ListEndpoints() []string {
+	return msp.endpoints.names()
+}
+
+// GetEndpointSource returns the config from which the endpoint of the given name was read, such as EndpointSourceDerived
+// if the endpoint was derived from the region.
+func (msp *ManagedSecretProvider) GetEndpointSource(name string) (EndpointSource, error) {
+	return msp.endpoints.getSource(name)
+}
+
+// DescribeEndpoints returns the registered endpoints along with the config, the key in it, and the time they were read.
+func (msp *ManagedSecretProvider) DescribeEndpoints() []EndpointInfo {
+	return msp.endpoints.describe()
+}
+
 // GetResourceGroupID ...
 func (msp *ManagedSecretProvider) GetResourceGroupID() string {
+	msp.configMutex.RLock()
+	defer msp.configMutex.RUnlock()
 	return msp.resourceGroupID
 }
 
+// GetRegion ...
+func (msp *ManagedSecretProvider) GetRegion() string {
+	msp.configMutex.RLock()
+	defer msp.configMutex.RUnlock()
+	return msp.region
+}
+
+// GetTokenExchangeURL returns the token exchange URL, which is read from the config on the first call in the same way as
+// the unmanaged secret provider, since the sidecar does not provide it.
+func (msp *ManagedSecretProvider) GetTokenExchangeURL() string {
+	ctx, cancel := context.WithTimeout(context.Background(), sidecarCallTimeout)
+	defer cancel()
+	tokenExchangeURL, _ := msp.getTokenExchangeURL(ctx)
+	return tokenExchangeURL
+}
+
+// IsPrivateTokenExchange ...
+func (msp *ManagedSecretProvider) IsPrivateTokenExchange() bool {
+	return isPrivateEndpoint(msp.GetTokenExchangeURL())
+}
+
+// Subscribe registers the given callback for the events, which is called until the returned function is called.
+// The secret is watched by the sidecar, hence only EndpointsChanged is emitted by the managed secret provider.
+func (msp *ManagedSecretProvider) Subscribe(callback func(Event)) func() {
+	return msp.subscribers.subscribe(callback)
+}
+
 // initEndpointsUsingCloudConf ...
-func (msp *ManagedSecretProvider) initEndpointsUsingCloudConf() error {
-	cloudConf, err := config.GetCloudConf(msp.logger, msp.k8sClient)
+func (msp *ManagedSecretProvider) initEndpointsUsingCloudConf(ctx context.Context) error {
+	cloudConf, err := getCloudConf(ctx, msp.k8sClient)
 	if err != nil {
 		return err
 	}
 
 	msp.region = cloudConf.Region
-	msp.containerAPIRoute = cloudConf.ContainerAPIRoute
-	msp.privateContainerAPIRoute = cloudConf.PrivateContainerAPIRoute
-	msp.riaasEndpoint = cloudConf.RiaasEndpoint
-	msp.privateRIAASEndpoint = cloudConf.PrivateRIAASEndpoint
+	if fields, err := getCloudConfFields(ctx, msp.k8sClient); err == nil {
+		msp.endpoints.setFromCloudConf(ctx, fields, msp.k8sClient, msp.logger)
+	}
 	msp.resourceGroupID = cloudConf.ResourceGroupID
 	return nil
 }
 
 // initEndpointsUsingStorageSecretStore ...
-func (msp *ManagedSecretProvider) initEndpointsUsingStorageSecretStore() error {
-	data, err := k8s_utils.GetSecretData(msp.k8sClient, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
-	if err != nil {
-		return err
-	}
-
-	conf, err := config.ParseConfig(msp.logger, data)
+func (msp *ManagedSecretProvider) initEndpointsUsingStorageSecretStore(ctx context.Context) error {
+	fields, err := getSecretStoreFields(ctx, msp.k8sClient)
 	if err != nil {
 		return err
 	}
 
-	msp.containerAPIRoute = conf.Bluemix.APIEndpointURL
-	msp.privateContainerAPIRoute = conf.Bluemix.PrivateAPIRoute
-	msp.riaasEndpoint = conf.VPC.G2EndpointURL
-	msp.privateRIAASEndpoint = conf.VPC.G2EndpointPrivateURL
-	msp.resourceGroupID = conf.VPC.G2ResourceGroupID
+	msp.endpoints.setFromSecretStore(fields, msp.logger)
+	msp.resourceGroupID = lookupField(fields, secretStoreResourceGroupPath)
 	return nil
 }
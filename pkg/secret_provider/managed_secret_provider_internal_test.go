@@ -0,0 +1,69 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixConnect(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "sidecar.sock")
+	listener, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("Unable to listen on %s: %v", addr, err)
+	}
+	defer listener.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name      string
+		ctx       context.Context
+		addr      string
+		expectErr bool
+		cancelled bool
+	}{
+		{name: "connected", ctx: context.Background(), addr: addr},
+		{name: "socket missing", ctx: context.Background(), addr: filepath.Join(t.TempDir(), "missing.sock"), expectErr: true},
+		{name: "context cancelled", ctx: cancelled, addr: addr, expectErr: true, cancelled: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := unixConnect(tc.ctx, tc.addr)
+			if !tc.expectErr {
+				if err != nil {
+					t.Fatalf("Unable to connect to %s: %v", tc.addr, err)
+				}
+				conn.Close()
+				return
+			}
+			if err == nil {
+				conn.Close()
+				t.Fatalf("Expected an error, connected to %s", tc.addr)
+			}
+			if tc.cancelled && !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected the dial to be cancelled, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// The tests are in an external package, since secretsidecartest imports secret_provider.
+package secret_provider_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	secret_provider "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	"github.com/IBM/secret-common-lib/pkg/secret_provider/secretsidecartest"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestManagedSecretProvider starts a fake sidecar, and initialises a managed secret provider using it.
+func newTestManagedSecretProvider(t *testing.T, opts ...secret_provider.Option) (*secret_provider.ManagedSecretProvider, *secretsidecartest.Server) {
+	t.Helper()
+	server, err := secretsidecartest.NewServer()
+	if err != nil {
+		t.Fatalf("Unable to start fake sidecar: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	k8sClient, err := k8s_utils.FakeGetk8sClientSet()
+	if err != nil {
+		t.Fatalf("Unable to create fake k8s client: %v", err)
+	}
+
+	opts = append(append(server.Options(), secret_provider.WithLogger(zap.NewNop())), opts...)
+	provider, err := secret_provider.NewSecretProviderWithOptions(&k8sClient, opts...)
+	if err != nil {
+		t.Fatalf("Unable to initialise managed secret provider: %v", err)
+	}
+	msp, ok := provider.(*secret_provider.ManagedSecretProvider)
+	if !ok {
+		t.Fatalf("Expected managed secret provider, got %T", provider)
+	}
+	t.Cleanup(func() { _ = msp.Close() })
+	return msp, server
+}
+
+func TestNewManagedSecretProvider(t *testing.T) {
+	_, server := newTestManagedSecretProvider(t, secret_provider.WithProviderType(secret_provider.VPC))
+
+	requests := server.Requests()
+	if len(requests) != 1 || requests[0].Method != secretsidecartest.MethodNewSecretProvider {
+		t.Fatalf("Expected a single NewSecretProvider call, got %+v", requests)
+	}
+	if requests[0].ProviderType != secret_provider.VPC {
+		t.Errorf("Expected provider type %q, got %q", secret_provider.VPC, requests[0].ProviderType)
+	}
+}
+
+func TestNewManagedSecretProviderInitError(t *testing.T) {
+	server, err := secretsidecartest.NewServer()
+	if err != nil {
+		t.Fatalf("Unable to start fake sidecar: %v", err)
+	}
+	defer server.Close()
+	server.SetInitError(status.Error(codes.Internal, "unable to read secret"))
+
+	k8sClient, _ := k8s_utils.FakeGetk8sClientSet()
+	// The sidecar is initialised only if the provider type is given
+	opts := append(server.Options(), secret_provider.WithLogger(zap.NewNop()), secret_provider.WithProviderType(secret_provider.VPC))
+	if _, err := secret_provider.NewSecretProviderWithOptions(&k8sClient, opts...); err == nil {
+		t.Fatal("Expected an error when the sidecar fails to initialise")
+	}
+}
+
+func TestGetDefaultIAMToken(t *testing.T) {
+	testcases := []struct {
+		name              string
+		response          secretsidecartest.Response
+		expectedToken     string
+		expectedLifetime  uint64
+		expectErr         bool
+		expectUnreachable bool
+	}{
+		{
+			name:             "token and lifetime",
+			response:         secretsidecartest.Response{Token: "default-token", TokenLifetime: 1200},
+			expectedToken:    "default-token",
+			expectedLifetime: 1200,
+		},
+		{
+			name:     "sidecar error",
+			response: secretsidecartest.Response{Err: status.Error(codes.Internal, "unable to fetch token")},
+			// An error from the sidecar is not an error reaching it
+			expectErr: true,
+		},
+		{
+			name:              "sidecar unavailable",
+			response:          secretsidecartest.Response{Err: status.Error(codes.Unavailable, "sidecar is restarting")},
+			expectErr:         true,
+			expectUnreachable: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			msp, server := newTestManagedSecretProvider(t, secret_provider.WithSidecarRetry(0, 0, 0))
+			server.SetDefaultResponse(tc.response)
+
+			token, tokenlifetime, err := msp.GetDefaultIAMToken(true, "test")
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				if unreachable := secret_provider.IsSidecarUnreachable(err); unreachable != tc.expectUnreachable {
+					t.Errorf("Expected IsSidecarUnreachable to be %v, got %v for %v", tc.expectUnreachable, unreachable, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if token != tc.expectedToken || tokenlifetime != tc.expectedLifetime {
+				t.Errorf("Expected %q and %d, got %q and %d", tc.expectedToken, tc.expectedLifetime, token, tokenlifetime)
+			}
+
+			requests := server.Requests()
+			last := requests[len(requests)-1]
+			if last.Method != secretsidecartest.MethodGetDefaultIAMToken || !last.IsFreshTokenRequired || last.ReasonForCall != "test" {
+				t.Errorf("Unexpected request %+v", last)
+			}
+		})
+	}
+}
+
+func TestGetIAMToken(t *testing.T) {
+	msp, server := newTestManagedSecretProvider(t)
+	server.SetDefaultResponse(secretsidecartest.Response{Token: "default-token", TokenLifetime: 1200})
+	server.SetSecretResponse("secret-a", secretsidecartest.Response{Token: "token-a", TokenLifetime: 600})
+
+	token, tokenlifetime, err := msp.GetIAMToken("secret-a", false)
+	if err != nil || token != "token-a" || tokenlifetime != 600 {
+		t.Errorf("Expected token-a and 600, got %q, %d and %v", token, tokenlifetime, err)
+	}
+
+	// A secret without a scripted response gets the default one
+	token, tokenlifetime, err = msp.GetIAMToken("secret-b", false)
+	if err != nil || token != "default-token" || tokenlifetime != 1200 {
+		t.Errorf("Expected default-token and 1200, got %q, %d and %v", token, tokenlifetime, err)
+	}
+
+	requests := server.Requests()
+	last := requests[len(requests)-1]
+	if last.Method != secretsidecartest.MethodGetIAMToken || last.Secret != "secret-b" || last.IsFreshTokenRequired {
+		t.Errorf("Unexpected request %+v", last)
+	}
+}
+
+func TestGetIAMTokenLatency(t *testing.T) {
+	msp, server := newTestManagedSecretProvider(t)
+	server.SetSecretResponse("slow-secret", secretsidecartest.Response{Token: "slow-token", TokenLifetime: 600, Latency: 200 * time.Millisecond})
+
+	// The call waits for the latency of the sidecar
+	start := time.Now()
+	token, _, err := msp.GetIAMToken("slow-secret", false)
+	if err != nil || token != "slow-token" {
+		t.Fatalf("Expected slow-token, got %q and %v", token, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected the call to wait for the latency, it returned in %v", elapsed)
+	}
+
+	// The call returns as soon as its context is done
+	server.SetSecretResponse("slow-secret", secretsidecartest.Response{Token: "slow-token", TokenLifetime: 600, Latency: 5 * time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, _, err = msp.GetIAMTokenContext(ctx, "slow-secret", false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the call to return when its context is done, it returned in %v", elapsed)
+	}
+}
+
+func TestGetIAMTokenConcurrent(t *testing.T) {
+	msp, server := newTestManagedSecretProvider(t)
+	server.SetDefaultResponse(secretsidecartest.Response{Token: "default-token", TokenLifetime: 1200, Latency: 100 * time.Millisecond})
+
+	// The concurrent calls for the token of the default secret share a single call to the sidecar
+	const callers = 10
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			token, _, err := msp.GetDefaultIAMToken(false)
+			if err == nil && token != "default-token" {
+				err = errors.New("unexpected token " + token)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < callers; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	calls := 0
+	for _, request := range server.Requests() {
+		if request.Method == secretsidecartest.MethodGetDefaultIAMToken {
+			calls++
+		}
+	}
+	if calls < 1 || calls >= callers {
+		t.Errorf("Expected the concurrent calls to be shared, the sidecar received %d calls", calls)
+	}
+}
@@ -0,0 +1,66 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"os"
+	"strings"
+)
+
+// Mode decides whether a managed or an unmanaged secret provider is initialised.
+type Mode string
+
+const (
+	// Auto uses IKS_ENABLED if it is set, else probes the sidecar endpoint.
+	Auto Mode = "auto"
+	// Managed always initialises the managed secret provider, which connects to the secret sidecar.
+	Managed Mode = "managed"
+	// Unmanaged always initialises the unmanaged secret provider.
+	Unmanaged Mode = "unmanaged"
+)
+
+// isMode ...
+func isMode(mode Mode) bool {
+	return (mode == Auto || mode == Managed || mode == Unmanaged)
+}
+
+// resolveMode returns true if the managed secret provider needs to be initialised.
+func resolveMode(mode Mode, sidecarEndpoint string) bool {
+	switch mode {
+	case Managed:
+		return true
+	case Unmanaged:
+		return false
+	}
+
+	// If IKS_ENABLED is set, it is honoured as is
+	if iksEnabled, ok := os.LookupEnv("IKS_ENABLED"); ok && iksEnabled != "" {
+		return strings.ToLower(iksEnabled) == "true"
+	}
+
+	// If IKS_ENABLED is not set, the managed secret provider is used only if the sidecar socket is present
+	return isSidecarSocketPresent(sidecarEndpoint)
+}
+
+// isSidecarSocketPresent ...
+func isSidecarSocketPresent(sidecarEndpoint string) bool {
+	fileInfo, err := os.Stat(sidecarEndpoint)
+	if err != nil {
+		return false
+	}
+	return fileInfo.Mode()&os.ModeSocket != 0
+}
@@ -0,0 +1,369 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"strings"
+	"time"
+
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Option configures the secret provider initialised by NewSecretProviderWithOptions.
+type Option func(*providerOptions) error
+
+// providerOptions holds the configuration collected from the provided options.
+type providerOptions struct {
+	providerType    string
+	secretKey       string
+	logger          *zap.Logger
+	logLevel        *zapcore.Level
+	sidecarEndpoint string
+	sidecarRetry    sidecarRetryPolicy
+	// sidecarRetrySet is true if the retry policy was provided, else the failover secret provider uses a shorter one.
+	sidecarRetrySet bool
+	fallback        bool
+	// fallbackRetryInterval is the time after which the sidecar is tried again, once it is found unreachable.
+	fallbackRetryInterval time.Duration
+	healthCheckInterval   time.Duration
+	namespace             string
+	mode                  Mode
+	tokenExpiryDiff       *time.Duration
+	tokenRefreshFraction  float64
+	secretWatcher         bool
+	secretCacheLimit      int
+	endpoints             []EndpointDefinition
+	endpointValidation    *endpointValidation
+	regionTemplates       map[string]string
+	configWatcher         bool
+	// endpointFallback is set if the endpoints missing in cloud-conf are read from storage-secret-store or derived from the region.
+	endpointFallback bool
+	// inClusterClient is set by NewSecretProvider, the managed secret provider then reads the config using the in
+	// cluster k8s client irrespective of the one provided, as done by the earlier releases.
+	inClusterClient bool
+}
+
+// WithProviderType sets the provider type (vpc, bluemix, softlayer) used when storage-secret-store is read.
+func WithProviderType(providerType string) Option {
+	return func(o *providerOptions) error {
+		if !isProviderType(providerType) {
+			return utils.Error{Description: localutils.ErrInvalidProviderType}
+		}
+		o.providerType = providerType
+		return nil
+	}
+}
+
+// WithSecretKey sets the key to be read from ibm-cloud-credentials or storage-secret-store instead of the default keys.
+func WithSecretKey(secretKey string) Option {
+	return func(o *providerOptions) error {
+		if secretKey == "" {
+			return utils.Error{Description: localutils.ErrEmptySecretKeyProvided}
+		}
+		o.secretKey = secretKey
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the secret provider, secret provider fields are attached to it.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *providerOptions) error {
+		if logger == nil {
+			return utils.Error{Description: localutils.ErrNilLogger}
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithLogrLogger sets a logr logger to which the logs of the secret provider are written.
+// Debug logs are written at V(1), the rest at V(0).
+func WithLogrLogger(logger logr.Logger) Option {
+	return func(o *providerOptions) error {
+		if logger.GetSink() == nil {
+			return utils.Error{Description: localutils.ErrNilLogger}
+		}
+		o.logger = newLogrLogger(logger)
+		return nil
+	}
+}
+
+// WithLogLevel sets the minimum level of the logs written by the secret provider, overrides SECRET_PROVIDER_LOG_LEVEL.
+// For a logger provided using WithLogger or WithLogrLogger, the level can only be increased beyond its own level.
+func WithLogLevel(level zapcore.Level) Option {
+	return func(o *providerOptions) error {
+		o.logLevel = &level
+		return nil
+	}
+}
+
+// WithSidecarEndpoint sets the unix socket on which the secret sidecar is listening, overrides the sidecarEndpoint flag.
+func WithSidecarEndpoint(sidecarEndpoint string) Option {
+	return func(o *providerOptions) error {
+		if sidecarEndpoint == "" {
+			return utils.Error{Description: localutils.ErrEmptySidecarEndpoint}
+		}
+		o.sidecarEndpoint = sidecarEndpoint
+		return nil
+	}
+}
+
+// WithSidecarConnectTimeout sets the time for which every attempt waits for the connection to the secret sidecar, defaults to 30s.
+// Zero means that the attempt waits until the context of the call is done.
+func WithSidecarConnectTimeout(timeout time.Duration) Option {
+	return func(o *providerOptions) error {
+		if timeout < 0 {
+			return utils.Error{Description: localutils.ErrInvalidSidecarRetry}
+		}
+		o.sidecarRetry.connectTimeout = timeout
+		o.sidecarRetrySet = true
+		return nil
+	}
+}
+
+// WithSidecarRetry sets the number of times a call is retried if the secret sidecar is unreachable, and the backoff between
+// the retries, which grows exponentially from initialBackoff up to maxBackoff, with jitter. Defaults to 3 retries, 1s and 10s.
+func WithSidecarRetry(retries int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(o *providerOptions) error {
+		if retries < 0 || initialBackoff < 0 || maxBackoff < 0 {
+			return utils.Error{Description: localutils.ErrInvalidSidecarRetry}
+		}
+		o.sidecarRetry.retries = retries
+		o.sidecarRetry.initialBackoff = initialBackoff
+		o.sidecarRetry.maxBackoff = maxBackoff
+		o.sidecarRetrySet = true
+		return nil
+	}
+}
+
+// WithUnmanagedFallback enables falling back to the unmanaged secret provider when the managed secret provider is chosen,
+// but the secret sidecar is unreachable. Once the sidecar is found unreachable, it is tried again after retryInterval,
+// which defaults to 30s if zero is given. Unless WithSidecarConnectTimeout or WithSidecarRetry is provided, the calls to
+// the sidecar wait for 2s for the connection and are retried once, so that the fallback is not delayed.
+func WithUnmanagedFallback(retryInterval time.Duration) Option {
+	return func(o *providerOptions) error {
+		if retryInterval < 0 {
+			return utils.Error{Description: localutils.ErrInvalidSidecarRetry}
+		}
+		if retryInterval == 0 {
+			retryInterval = defaultFallbackRetryInterval
+		}
+		o.fallback = true
+		o.fallbackRetryInterval = retryInterval
+		return nil
+	}
+}
+
+// WithSidecarHealthCheck starts a background health checker which checks the health of the secret sidecar every interval.
+// The result of the last check is returned by HealthStatus and decides the routing when WithUnmanagedFallback is used.
+func WithSidecarHealthCheck(interval time.Duration) Option {
+	return func(o *providerOptions) error {
+		if interval <= 0 {
+			return utils.Error{Description: localutils.ErrInvalidHealthCheckInterval}
+		}
+		o.healthCheckInterval = interval
+		return nil
+	}
+}
+
+// WithNamespace sets the namespace from which the k8s secrets and config maps are read.
+func WithNamespace(namespace string) Option {
+	return func(o *providerOptions) error {
+		if namespace == "" {
+			return utils.Error{Description: localutils.ErrEmptyNamespace}
+		}
+		o.namespace = namespace
+		return nil
+	}
+}
+
+// WithMode sets whether a managed or an unmanaged secret provider needs to be initialised, defaults to Auto.
+func WithMode(mode Mode) Option {
+	return func(o *providerOptions) error {
+		if !isMode(mode) {
+			return utils.Error{Description: localutils.ErrInvalidMode}
+		}
+		o.mode = mode
+		return nil
+	}
+}
+
+// WithTokenExpiryDiff sets the minimum validity of the token returned by the unmanaged secret provider, a fresh token
+// is fetched if the cached one expires sooner. Overrides TOKEN_EXPIRY_DIFF, defaults to 5m.
+func WithTokenExpiryDiff(tokenExpiryDiff time.Duration) Option {
+	return func(o *providerOptions) error {
+		if tokenExpiryDiff < 0 {
+			return utils.Error{Description: localutils.ErrInvalidTokenExpiryDiff}
+		}
+		o.tokenExpiryDiff = &tokenExpiryDiff
+		return nil
+	}
+}
+
+// WithTokenRefresh starts a background token refresher in the unmanaged secret provider, which fetches the token of the
+// default secret when the given fraction (such as 0.8) of its lifetime has passed, with jitter. The refresher is stopped by Close.
+func WithTokenRefresh(fraction float64) Option {
+	return func(o *providerOptions) error {
+		if fraction <= 0 || fraction >= 1 {
+			return utils.Error{Description: localutils.ErrInvalidTokenRefreshFraction}
+		}
+		o.tokenRefreshFraction = fraction
+		return nil
+	}
+}
+
+// WithSecretWatcher starts a secret watcher in the unmanaged secret provider, which reloads the credentials when
+// ibm-cloud-credentials or storage-secret-store is updated, without restarting the pod. The watcher is stopped by Close.
+func WithSecretWatcher() Option {
+	return func(o *providerOptions) error {
+		o.secretWatcher = true
+		return nil
+	}
+}
+
+// WithConfigWatcher starts a config watcher, which reads the endpoints again when the cloud-conf config map or
+// storage-secret-store is updated, without restarting the pod. The endpoints read using GetEndpoint are then read from
+// the cache of the watcher, instead of the API server. The watcher is stopped by Close.
+func WithConfigWatcher() Option {
+	return func(o *providerOptions) error {
+		o.configWatcher = true
+		return nil
+	}
+}
+
+// WithSecretCacheLimit sets the number of secrets provided in GetIAMToken, whose authenticators and tokens are cached by
+// the unmanaged secret provider. Overrides SECRET_CACHE_LIMIT, defaults to 10.
+func WithSecretCacheLimit(limit int) Option {
+	return func(o *providerOptions) error {
+		if limit <= 0 {
+			return utils.Error{Description: localutils.ErrInvalidSecretCacheLimit}
+		}
+		o.secretCacheLimit = limit
+		return nil
+	}
+}
+
+// WithEndpoint registers the given endpoint, which can be read using GetEndpoint. An endpoint registered with the name of
+// a built in endpoint, such as RIAAS, replaces it.
+func WithEndpoint(definition EndpointDefinition) Option {
+	return func(o *providerOptions) error {
+		if err := definition.validate(); err != nil {
+			return err
+		}
+		o.endpoints = append(o.endpoints, definition)
+		return nil
+	}
+}
+
+// WithEndpointValidation validates the endpoints read from cloud-conf, they must be http or https URLs with a host, and
+// are probed using the given probe, such as TCPProbe or HTTPHeadProbe, if it is not nil. If an endpoint fails the
+// validation, the one in storage-secret-store is used instead, else the validation error is returned.
+func WithEndpointValidation(probe EndpointProbe) Option {
+	return func(o *providerOptions) error {
+		o.endpointValidation = &endpointValidation{probe: probe}
+		return nil
+	}
+}
+
+// WithEndpointFallback reads the endpoints which are not present in cloud-conf from storage-secret-store, and if it does
+// not provide them either, derives them from the region in cloud-conf. Without it, once cloud-conf is read, its endpoints
+// are taken as-is while initialising the secret provider, and the endpoints are not derived.
+func WithEndpointFallback() Option {
+	return func(o *providerOptions) error {
+		o.endpointFallback = true
+		return nil
+	}
+}
+
+// WithRegionTemplate overrides the region template of the endpoint of the given name, such as RIAAS, using which the
+// endpoint is derived if neither cloud-conf nor storage-secret-store provides it. An empty template disables deriving it.
+// A non empty template enables WithEndpointFallback.
+func WithRegionTemplate(name, template string) Option {
+	return func(o *providerOptions) error {
+		if name == "" {
+			return utils.Error{Description: localutils.ErrInvalidEndpointDefinition, BackendError: "endpoint name is empty"}
+		}
+		if template != "" && !strings.Contains(template, regionPlaceholder) {
+			return utils.Error{Description: localutils.ErrInvalidEndpointDefinition, BackendError: "region template of " + name + " does not contain " + regionPlaceholder}
+		}
+		if o.regionTemplates == nil {
+			o.regionTemplates = make(map[string]string)
+		}
+		o.regionTemplates[name] = template
+		if template != "" {
+			o.endpointFallback = true
+		}
+		return nil
+	}
+}
+
+// newProviderOptions applies the given options on top of the defaults, the first error seen is returned.
+func newProviderOptions(opts ...Option) (*providerOptions, error) {
+	o := &providerOptions{sidecarEndpoint: *endpoint, sidecarRetry: defaultSidecarRetryPolicy(), mode: Auto}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(o); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// optionsFromArgs converts the deprecated optionalArgs map into options.
+func optionsFromArgs(optionalArgs ...map[string]string) []Option {
+	var opts []Option
+	if len(optionalArgs) == 0 {
+		return opts
+	}
+
+	if providerName, ok := optionalArgs[0][ProviderType]; ok {
+		opts = append(opts, WithProviderType(providerName))
+	}
+	if secretKeyName, ok := optionalArgs[0][SecretKey]; ok {
+		opts = append(opts, WithSecretKey(secretKeyName))
+	}
+	return opts
+}
+
+// withInClusterClient ...
+func withInClusterClient() Option {
+	return func(o *providerOptions) error {
+		o.inClusterClient = true
+		return nil
+	}
+}
+
+// authArgs returns the arguments in the form expected by the authenticator.
+func (o *providerOptions) authArgs() []map[string]string {
+	args := make(map[string]string)
+	if o.providerType != "" {
+		args[ProviderType] = o.providerType
+	}
+	if o.secretKey != "" {
+		args[SecretKey] = o.secretKey
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return []map[string]string{args}
+}
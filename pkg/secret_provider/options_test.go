@@ -0,0 +1,113 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestManagedK8sClient(t *testing.T) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		t.Skip("The in cluster k8s client is available")
+	}
+
+	testCases := []struct {
+		name      string
+		opts      []Option
+		expectErr bool
+	}{
+		{name: "provided client", opts: nil},
+		{name: "provided client in namespace", opts: []Option{WithNamespace("kube-system")}},
+		{name: "in cluster client of NewSecretProvider", opts: []Option{withInClusterClient()}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kc := newFakeK8sClient(t, "https://iam.cloud.ibm.com")
+			msp, err := initManagedSecretProvider(context.Background(), &kc, zap.NewNop(), newTestProviderOptions(t, tc.opts...))
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("Expected the in cluster k8s client to be created, irrespective of the provided one")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unable to initialise managed secret provider: %v", err)
+			}
+			if msp.k8sClient.Clientset != kc.Clientset {
+				t.Error("Expected the provided k8s client to be used")
+			}
+
+			namespace := kc.Namespace
+			if o := newTestProviderOptions(t, tc.opts...); o.namespace != "" {
+				namespace = o.namespace
+			}
+			if msp.k8sClient.Namespace != namespace {
+				t.Errorf("Expected namespace %q, got %q", namespace, msp.k8sClient.Namespace)
+			}
+		})
+	}
+}
+
+func TestTokenExpiryDiff(t *testing.T) {
+	testCases := []struct {
+		name      string
+		env       string
+		opts      []Option
+		expected  time.Duration
+		expectErr bool
+	}{
+		{name: "default", expected: DefaultTokenExpiryDiff},
+		{name: "env", env: "20m", expected: 20 * time.Minute},
+		{name: "invalid env", env: "twenty", expected: DefaultTokenExpiryDiff},
+		{name: "negative env", env: "-1m", expected: DefaultTokenExpiryDiff},
+		{name: "option", opts: []Option{WithTokenExpiryDiff(time.Minute)}, expected: time.Minute},
+		{name: "zero option", opts: []Option{WithTokenExpiryDiff(0)}, expected: 0},
+		{name: "option takes precedence over env", env: "20m", opts: []Option{WithTokenExpiryDiff(time.Minute)}, expected: time.Minute},
+		{name: "negative option", opts: []Option{WithTokenExpiryDiff(-time.Minute)}, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(TokenExpiryDiffEnv, tc.env)
+			opts, err := newProviderOptions(tc.opts...)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("Expected an error for the invalid token expiry diff")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Invalid options: %v", err)
+			}
+
+			kc := newFakeK8sClient(t, "https://iam.cloud.ibm.com")
+			usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), opts)
+			if err != nil {
+				t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+			}
+			if usp.tokenExpiryDiff != tc.expected {
+				t.Errorf("Expected token expiry diff %v, got %v", tc.expected, usp.tokenExpiryDiff)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@
 package secret_provider
 
 import (
+	"context"
 	"os"
 	"strings"
 
@@ -36,35 +37,97 @@ const (
 	Softlayer    string = "softlayer"
 )
 
+// ContextSecretProvider is implemented by both managed and unmanaged secret providers, it provides the variants of
+// SecretProviderInterface methods which honour the cancellation and deadline of the given context.
+type ContextSecretProvider interface {
+	sp.SecretProviderInterface
+
+	// GetIAMTokenContext ...
+	GetIAMTokenContext(ctx context.Context, secret string, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error)
+
+	// GetDefaultIAMTokenContext ...
+	GetDefaultIAMTokenContext(ctx context.Context, freshTokenRequired bool, reasonForCall ...string) (string, uint64, error)
+
+	// GetRIAASEndpointContext ...
+	GetRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error)
+
+	// GetPrivateRIAASEndpointContext ...
+	GetPrivateRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error)
+
+	// GetContainerAPIRouteContext ...
+	GetContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error)
+
+	// GetPrivateContainerAPIRouteContext ...
+	GetPrivateContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error)
+}
+
+// CloudConfigProvider is implemented by the managed, unmanaged and failover secret providers, it provides the config
+// read by the secret provider, so that the callers need not read cloud-conf themselves.
+type CloudConfigProvider interface {
+	// GetRegion returns the region read from cloud-conf, it is empty if cloud-conf is not present.
+	GetRegion() string
+
+	// GetTokenExchangeURL returns the URL using which the IAM tokens are fetched.
+	GetTokenExchangeURL() string
+
+	// IsPrivateTokenExchange returns true if the token exchange URL is a private endpoint.
+	IsPrivateTokenExchange() bool
+}
+
 // NewSecretProvider initializes new secret provider
 // argument1: k8sClient - this is the k8s client which holds k8s clientset and namespace which the client code must pass, if they are intending to use only unmanaged secret provider.
 // argument2: optionalArgs - in this map, two keys can be provided - 1. providerType which can be VPC, Bluemix, Softlayer (the constants defined above) and is only used when we need to read storage-secret-store, this is kept to support backward compatibility.
 // and 2. SecretKey which is given, when different keys other than the default needs to be referred. (Defaults are slclient.toml in storage-secret-store, ibm-credetentials.env in ibm-cloud-credentials.)
+//
+// Deprecated: Use NewSecretProviderWithOptions along with WithProviderType and WithSecretKey.
 func NewSecretProvider(k8sClient *k8s_utils.KubernetesClient, optionalArgs ...map[string]string) (sp.SecretProviderInterface, error) {
-	var managed bool
-	if iksEnabled := os.Getenv("IKS_ENABLED"); strings.ToLower(iksEnabled) == "true" {
-		managed = true
-	}
-	logger := setUpLogger(managed)
-
 	err := validateArguments(optionalArgs...)
 	if err != nil {
+		logger := setUpLogger(isIKSEnabled(), getLogLevel())
 		logger.Error("Error seen while validating arguments", zap.Error(err), zap.Any("Provided arguments", optionalArgs))
 		return nil, err
 	}
 
-	if managed { // If IKS_ENABLED is set to true
-		if len(optionalArgs) == 0 {
-			return newManagedSecretProvider(logger)
-		}
-		// If ProviderType is given, fetch providerName and pass to initialise managed secret provider
-		if providerName, ok := optionalArgs[0][ProviderType]; ok {
-			return newManagedSecretProvider(logger, providerName)
-		}
+	return NewSecretProviderWithOptions(k8sClient, append(optionsFromArgs(optionalArgs...), withInClusterClient())...)
+}
+
+// NewSecretProviderWithOptions initializes new secret provider
+// argument1: k8sClient - this is the k8s client which holds k8s clientset and namespace which the client code must pass, if they are intending to use only unmanaged secret provider.
+// Managed secret provider also reads the config using it, unlike NewSecretProvider. If it is not provided, managed secret provider uses the in cluster k8s client.
+// argument2: opts - options such as WithProviderType, WithSecretKey, WithLogger, WithMode, WithSidecarEndpoint, WithUnmanagedFallback, WithNamespace.
+func NewSecretProviderWithOptions(k8sClient *k8s_utils.KubernetesClient, opts ...Option) (sp.SecretProviderInterface, error) {
+	o, err := newProviderOptions(opts...)
+	if err != nil {
+		// The mode is not resolved using invalid options, hence the sidecar socket is not probed
+		logger := o.newLogger(isIKSEnabled())
+		logger.Error("Error seen while validating options", zap.Error(err))
+		return nil, err
+	}
+
+	// Managed secret provider does not support SecretKey, in auto mode if a secret key was passed, initialise unmanaged secret provider
+	managed := resolveMode(o.mode, o.sidecarEndpoint) && (o.mode == Managed || o.secretKey == "")
+	logger := o.newLogger(managed)
+
+	if managed && o.secretKey != "" {
+		logger.Error("Secret key provided in managed mode", zap.String("Key", o.secretKey))
+		return nil, utils.Error{Description: localutils.ErrSecretKeyUnsupported}
+	}
+
+	if managed && o.fallback {
+		return newFailoverSecretProvider(k8sClient, logger, o)
 	}
 
-	// If a secret key was passed, or IKS ENABLED was set to false, initialise unmanaged secret provider
-	return newUnmanagedSecretProvider(k8sClient, logger, optionalArgs...)
+	if managed {
+		return newManagedSecretProvider(k8sClient, logger, o)
+	}
+
+	// If a secret key was passed, or unmanaged mode was resolved, initialise unmanaged secret provider
+	return newUnmanagedSecretProvider(k8sClient, logger, o)
+}
+
+// isIKSEnabled ...
+func isIKSEnabled() bool {
+	return strings.ToLower(os.Getenv("IKS_ENABLED")) == "true"
 }
 
 // validateArguments ...
@@ -101,25 +164,45 @@ func isProviderType(arg string) bool {
 	return (arg == VPC || arg == Bluemix || arg == Softlayer)
 }
 
+// newLogger returns the logger provided in the options with the secret provider fields attached, or a new logger.
+func (o *providerOptions) newLogger(managed bool) *zap.Logger {
+	level := getLogLevel()
+	if o.logLevel != nil {
+		level = *o.logLevel
+	}
+
+	if o.logger == nil {
+		return setUpLogger(managed, level)
+	}
+
+	logger := o.logger
+	if level > loggerLevel(logger) {
+		logger = logger.WithOptions(zap.IncreaseLevel(level))
+	}
+	return logger.With(zap.String("name", "secret-provider")).With(zap.String("secret-provider-type", secretProviderType(managed)))
+}
+
+// secretProviderType ...
+func secretProviderType(managed bool) string {
+	if managed {
+		return "managed-secret-provider"
+	}
+	return "unmanaged-secret-provider"
+}
+
 // setUpLogger ...
-func setUpLogger(managed bool) *zap.Logger {
+func setUpLogger(managed bool, level zapcore.Level) *zap.Logger {
 	// Prepare a new logger
 	atom := zap.NewAtomicLevel()
 	encoderCfg := zap.NewProductionEncoderConfig()
 	encoderCfg.TimeKey = "timestamp"
 	encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
-	var secretProviderType string
-	if managed {
-		secretProviderType = "managed-secret-provider"
-	} else {
-		secretProviderType = "unmanaged-secret-provider"
-	}
 	logger := zap.New(zapcore.NewCore(
 		zapcore.NewJSONEncoder(encoderCfg),
 		zapcore.Lock(os.Stdout),
 		atom,
-	), zap.AddCaller()).With(zap.String("name", "secret-provider")).With(zap.String("secret-provider-type", secretProviderType))
+	), zap.AddCaller()).With(zap.String("name", "secret-provider")).With(zap.String("secret-provider-type", secretProviderType(managed)))
 
-	atom.SetLevel(zap.InfoLevel)
+	atom.SetLevel(level)
 	return logger
 }
@@ -0,0 +1,141 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretWatcher watches ibm-cloud-credentials and storage-secret-store, using an informer for each.
+type secretWatcher struct {
+	stop      chan struct{}
+	factories []informers.SharedInformerFactory
+	closeOnce sync.Once
+}
+
+// startSecretWatcher starts watching the secrets, the credentials are reloaded whenever either of them is created, updated or deleted.
+// Since ibm-cloud-credentials is preferred over storage-secret-store, deleting it switches to storage-secret-store and vice versa.
+func (usp *UnmanagedSecretProvider) startSecretWatcher() {
+	sw := &secretWatcher{stop: make(chan struct{})}
+	for _, secretName := range []string{utils.IBMCLOUD_CREDENTIALS_SECRET, utils.STORAGE_SECRET_STORE_SECRET} {
+		factory := informers.NewSharedInformerFactoryWithOptions(usp.k8sClient.Clientset, 0,
+			informers.WithNamespace(usp.k8sClient.Namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", secretName).String()
+			}))
+
+		_, err := factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				// The secrets present at the start were read while initialising the secret provider
+				if !isInInitialList && isSecret(obj, secretName) {
+					usp.reloadSecret(secretName, "created")
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldSecret, _ := oldObj.(*v1.Secret)
+				newSecret, ok := newObj.(*v1.Secret)
+				if ok && newSecret.Name == secretName && (oldSecret == nil || !reflect.DeepEqual(oldSecret.Data, newSecret.Data)) {
+					usp.reloadSecret(secretName, "updated")
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if isSecret(obj, secretName) {
+					usp.reloadSecret(secretName, "deleted")
+				}
+			},
+		})
+		if err != nil {
+			usp.logger.Error("Unable to watch secret", zap.String("secret", secretName), zap.Error(err))
+			continue
+		}
+
+		factory.Start(sw.stop)
+		sw.factories = append(sw.factories, factory)
+	}
+
+	usp.backgroundMutex.Lock()
+	usp.secretWatcher = sw
+	usp.backgroundMutex.Unlock()
+	usp.logger.Info("Started secret watcher")
+}
+
+// stopSecretWatcher stops the secret watcher, if it is running.
+func (usp *UnmanagedSecretProvider) stopSecretWatcher() {
+	usp.backgroundMutex.Lock()
+	sw := usp.secretWatcher
+	usp.secretWatcher = nil
+	usp.backgroundMutex.Unlock()
+	if sw == nil {
+		return
+	}
+
+	sw.closeOnce.Do(func() { close(sw.stop) })
+	for _, factory := range sw.factories {
+		factory.Shutdown()
+	}
+}
+
+// reloadSecret reads the secrets again and replaces the authenticator, the cached token is removed.
+// If neither of the secrets can be read, the previous credentials continue to be used.
+func (usp *UnmanagedSecretProvider) reloadSecret(secretName, event string) {
+	usp.logger.Info("Secret changed, reloading credentials", zap.String("secret", secretName), zap.String("event", event))
+	authenticator, authType, err := newAuthenticator(usp.logger, usp.k8sClient, usp.authArgs...)
+	if err != nil {
+		usp.logger.Error("Unable to reload credentials, continuing to use the previous credentials", zap.Error(err))
+		return
+	}
+	// The token exchange URL is read while holding tokenMutex, so that it is not replaced by the config watcher meanwhile
+	usp.tokenMutex.Lock()
+	authenticator.SetURL(usp.getTokenExchangeURL())
+	usp.authMutex.Lock()
+	previousAuthenticator, previousAuthType := usp.authenticator, usp.authType
+	usp.authenticator = authenticator
+	usp.authType = authType
+	usp.authMutex.Unlock()
+	usp.defaultToken.clear()
+	usp.tokenMutex.Unlock()
+	usp.logger.Info("Reloaded credentials", zap.String("auth-type", authType))
+
+	// The events are published after releasing the locks, so that the callbacks can fetch the token
+	if source, previousSource := secretSource(authType), secretSource(previousAuthType); source != previousSource {
+		usp.subscribers.publish(Event{Type: SecretSourceSwitched, Secret: source, PreviousSecret: previousSource})
+	}
+	if authType != previousAuthType {
+		usp.subscribers.publish(Event{Type: AuthTypeChanged, AuthType: authType, PreviousAuthType: previousAuthType})
+	}
+	if authenticator.GetSecret() != previousAuthenticator.GetSecret() {
+		usp.subscribers.publish(Event{Type: CredentialRotated})
+	}
+}
+
+// isSecret ...
+func isSecret(obj interface{}, secretName string) bool {
+	secret, ok := obj.(*v1.Secret)
+	return ok && secret.Name == secretName
+}
@@ -0,0 +1,217 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// testStorageSecretStore is the content of storage-secret-store, holding the api key storage-secret-store-key.
+	testStorageSecretStore = `[VPC]
+  g2_token_exchange_endpoint_url = "https://iam.cloud.ibm.com"
+  g2_riaas_endpoint_url = "https://us-south.iaas.cloud.ibm.com"
+  g2_riaas_endpoint_private_url = "https://us-south.private.iaas.cloud.ibm.com"
+  g2_api_key = "storage-secret-store-key"
+  provider_type = "g2"
+`
+)
+
+// setSecret creates or updates the given secret in the fake cluster.
+func setSecret(t *testing.T, kc k8s_utils.KubernetesClient, secretName, dataName, data string, labels map[string]string) {
+	t.Helper()
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: kc.Namespace, Labels: labels},
+		Data:       map[string][]byte{dataName: []byte(data)},
+	}
+	secrets := kc.Clientset.CoreV1().Secrets(kc.Namespace)
+	if _, err := secrets.Update(context.TODO(), secret, metav1.UpdateOptions{}); err == nil {
+		return
+	}
+	if _, err := secrets.Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to set %s: %v", secretName, err)
+	}
+}
+
+// setAPIKey sets the given api key in ibm-cloud-credentials.
+func setAPIKey(t *testing.T, kc k8s_utils.KubernetesClient, apiKey string, labels map[string]string) {
+	t.Helper()
+	setSecret(t, kc, utils.IBMCLOUD_CREDENTIALS_SECRET, utils.CLOUD_PROVIDER_ENV, "IBMCLOUD_AUTHTYPE=iam\nIBMCLOUD_APIKEY="+apiKey+"\n", labels)
+}
+
+func TestSecretWatcher(t *testing.T) {
+	testCases := []struct {
+		name           string
+		setup          func(t *testing.T, kc k8s_utils.KubernetesClient)
+		change         func(t *testing.T, kc k8s_utils.KubernetesClient)
+		expectedEvents []Event
+		expectedSecret string
+		expectedAPIKey string
+	}{
+		{
+			name: "api key rotated",
+			change: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				setAPIKey(t, kc, "rotated-key", nil)
+			},
+			expectedEvents: []Event{{Type: CredentialRotated}},
+			expectedSecret: "rotated-key",
+			expectedAPIKey: "rotated-key",
+		},
+		{
+			name: "unchanged data is not reloaded",
+			change: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				setAPIKey(t, kc, "initial-key", map[string]string{"updated": "true"})
+				setAPIKey(t, kc, "rotated-key", nil)
+			},
+			expectedEvents: []Event{{Type: CredentialRotated}},
+			expectedSecret: "rotated-key",
+			expectedAPIKey: "rotated-key",
+		},
+		{
+			name: "switched to trusted profile",
+			change: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				setSecret(t, kc, utils.IBMCLOUD_CREDENTIALS_SECRET, utils.CLOUD_PROVIDER_ENV, "IBMCLOUD_AUTHTYPE=pod-identity\nIBMCLOUD_PROFILEID=profile-id\n", nil)
+			},
+			expectedEvents: []Event{
+				{Type: AuthTypeChanged, AuthType: utils.PODIDENTITY, PreviousAuthType: utils.IAM},
+				{Type: CredentialRotated},
+			},
+			expectedSecret: "profile-id",
+		},
+		{
+			name: "ibm-cloud-credentials deleted",
+			setup: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				setSecret(t, kc, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE, testStorageSecretStore, nil)
+			},
+			change: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				if err := kc.Clientset.CoreV1().Secrets(kc.Namespace).Delete(context.TODO(), utils.IBMCLOUD_CREDENTIALS_SECRET, metav1.DeleteOptions{}); err != nil {
+					t.Fatalf("Unable to delete ibm-cloud-credentials: %v", err)
+				}
+			},
+			expectedEvents: []Event{
+				{Type: SecretSourceSwitched, Secret: utils.STORAGE_SECRET_STORE_SECRET, PreviousSecret: utils.IBMCLOUD_CREDENTIALS_SECRET},
+				{Type: AuthTypeChanged, AuthType: utils.DEFAULT, PreviousAuthType: utils.IAM},
+				{Type: CredentialRotated},
+			},
+			expectedSecret: "storage-secret-store-key",
+			expectedAPIKey: "storage-secret-store-key",
+		},
+		{
+			name: "ibm-cloud-credentials created",
+			setup: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				setSecret(t, kc, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE, testStorageSecretStore, nil)
+				if err := kc.Clientset.CoreV1().Secrets(kc.Namespace).Delete(context.TODO(), utils.IBMCLOUD_CREDENTIALS_SECRET, metav1.DeleteOptions{}); err != nil {
+					t.Fatalf("Unable to delete ibm-cloud-credentials: %v", err)
+				}
+			},
+			change: func(t *testing.T, kc k8s_utils.KubernetesClient) {
+				setAPIKey(t, kc, "rotated-key", nil)
+			},
+			expectedEvents: []Event{
+				{Type: SecretSourceSwitched, Secret: utils.IBMCLOUD_CREDENTIALS_SECRET, PreviousSecret: utils.STORAGE_SECRET_STORE_SECRET},
+				{Type: AuthTypeChanged, AuthType: utils.IAM, PreviousAuthType: utils.DEFAULT},
+				{Type: CredentialRotated},
+			},
+			expectedSecret: "rotated-key",
+			expectedAPIKey: "rotated-key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeIAMServer(t)
+			kc := newFakeK8sClient(t, server.URL)
+			setAPIKey(t, kc, "initial-key", nil)
+			if tc.setup != nil {
+				tc.setup(t, kc)
+			}
+			started := watchesStarted(t, kc)
+
+			core, logs := observer.New(zap.InfoLevel)
+			usp, err := newUnmanagedSecretProvider(&kc, zap.New(core), newTestProviderOptions(t, WithSecretWatcher()))
+			if err != nil {
+				t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+			}
+			defer usp.Close()
+			events := make(chan Event, 10)
+			defer usp.Subscribe(func(event Event) { events <- event })()
+
+			// The token fetched before the change is cached
+			if _, _, err := usp.GetDefaultIAMToken(false); err != nil {
+				t.Fatalf("Unable to fetch token: %v", err)
+			}
+			waitForWatches(t, started, 2)
+			tc.change(t, kc)
+
+			var received []Event
+			timeout := time.After(5 * time.Second)
+			for len(received) < len(tc.expectedEvents) {
+				select {
+				case event := <-events:
+					received = append(received, event)
+				case <-timeout:
+					t.Fatalf("Expected events %+v, got %+v", tc.expectedEvents, received)
+				}
+			}
+			select {
+			case event := <-events:
+				received = append(received, event)
+			case <-time.After(200 * time.Millisecond):
+			}
+			if !reflect.DeepEqual(received, tc.expectedEvents) {
+				t.Errorf("Expected events %+v, got %+v", tc.expectedEvents, received)
+			}
+
+			// Every change is reloaded once, the change of labels alone is not reloaded
+			if reloads := logs.FilterMessage("Secret changed, reloading credentials").Len(); reloads != 1 {
+				t.Errorf("Expected the credentials to be reloaded once, reloaded %d times", reloads)
+			}
+
+			usp.authMutex.RLock()
+			secret := usp.authenticator.GetSecret()
+			usp.authMutex.RUnlock()
+			if secret != tc.expectedSecret {
+				t.Errorf("Expected the authenticator to be reloaded with %q, got %q", tc.expectedSecret, secret)
+			}
+
+			// The cached token is cleared, the next token is fetched using the reloaded credentials
+			if _, _, ok := usp.defaultToken.get(0); ok {
+				t.Error("Expected the cached token to be cleared")
+			}
+			if tc.expectedAPIKey == "" {
+				return
+			}
+			if _, _, err := usp.GetDefaultIAMToken(false); err != nil {
+				t.Fatalf("Unable to fetch token: %v", err)
+			}
+			requests := server.requests()
+			if len(requests) != 2 || requests[1]["apikey"] != tc.expectedAPIKey {
+				t.Errorf("Expected a second request to IAM using %q, got %v", tc.expectedAPIKey, requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package secretsidecartest provides an in-process fake of storage-secret-sidecar, to test the managed secret provider without a cluster.
+package secretsidecartest
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	secret_provider "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	sp "github.com/IBM/secret-utils-lib/secretprovider"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultIAMToken is the token returned when no response is set.
+	DefaultIAMToken = "fake-iam-token"
+
+	// DefaultTokenLifetime is the token lifetime returned when no response is set.
+	DefaultTokenLifetime uint64 = 3600
+
+	// MethodNewSecretProvider ...
+	MethodNewSecretProvider = "NewSecretProvider"
+
+	// MethodGetIAMToken ...
+	MethodGetIAMToken = "GetIAMToken"
+
+	// MethodGetDefaultIAMToken ...
+	MethodGetDefaultIAMToken = "GetDefaultIAMToken"
+
+	// socketName is the name of the unix socket created in a temp directory.
+	socketName = "provider.sock"
+)
+
+// Response is the scripted response of the fake sidecar for a token call.
+type Response struct {
+	Token         string
+	TokenLifetime uint64
+	// Latency is the time for which the call waits before responding, the call returns early if its context is done.
+	Latency time.Duration
+	// Err is returned instead of the token, if set. Use status.Error to return a grpc status code.
+	Err error
+}
+
+// Request is a call received by the fake sidecar.
+type Request struct {
+	Method               string
+	ProviderType         string
+	Secret               string
+	IsFreshTokenRequired bool
+	ReasonForCall        string
+}
+
+// Server is an in-process fake sidecar serving the secretprovider grpc service and the grpc health service on a unix socket.
+type Server struct {
+	sp.UnimplementedSecretProviderServer
+
+	dir          string
+	endpoint     string
+	grpcServer   *grpc.Server
+	healthServer *health.Server
+	closeOnce    sync.Once
+
+	mutex           sync.Mutex
+	initErr         error
+	defaultResponse Response
+	secretResponses map[string]Response
+	requests        []Request
+}
+
+// NewServer starts a fake sidecar on a unix socket in a new temp directory. Close must be called to stop it.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "secretsidecar")
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := filepath.Join(dir, socketName)
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	s := &Server{
+		dir:             dir,
+		endpoint:        endpoint,
+		grpcServer:      grpc.NewServer(),
+		healthServer:    health.NewServer(),
+		defaultResponse: Response{Token: DefaultIAMToken, TokenLifetime: DefaultTokenLifetime},
+		secretResponses: make(map[string]Response),
+	}
+	sp.RegisterSecretProviderServer(s.grpcServer, s)
+	healthpb.RegisterHealthServer(s.grpcServer, s.healthServer)
+
+	go func() {
+		_ = s.grpcServer.Serve(listener)
+	}()
+	return s, nil
+}
+
+// Endpoint returns the unix socket on which the fake sidecar is listening.
+func (s *Server) Endpoint() string {
+	return s.endpoint
+}
+
+// Options returns the options which point a managed secret provider to the fake sidecar.
+func (s *Server) Options() []secret_provider.Option {
+	return []secret_provider.Option{secret_provider.WithMode(secret_provider.Managed), secret_provider.WithSidecarEndpoint(s.endpoint)}
+}
+
+// SetInitError sets the error returned by NewSecretProvider calls, nil makes them succeed.
+func (s *Server) SetInitError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.initErr = err
+}
+
+// SetDefaultResponse sets the response for GetDefaultIAMToken calls, and for GetIAMToken calls on secrets without a response set.
+func (s *Server) SetDefaultResponse(response Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.defaultResponse = response
+}
+
+// SetSecretResponse sets the response for GetIAMToken calls made with the given secret.
+func (s *Server) SetSecretResponse(secret string, response Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.secretResponses[secret] = response
+}
+
+// SetServingStatus sets the status returned by the grpc health service.
+func (s *Server) SetServingStatus(servingStatus healthpb.HealthCheckResponse_ServingStatus) {
+	s.healthServer.SetServingStatus("", servingStatus)
+}
+
+// Requests returns the calls received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Close stops the fake sidecar and removes its unix socket.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.grpcServer.Stop()
+		_ = os.RemoveAll(s.dir)
+	})
+}
+
+// NewSecretProvider ...
+func (s *Server) NewSecretProvider(ctx context.Context, req *sp.InitRequest) (*sp.Empty, error) {
+	s.mutex.Lock()
+	s.requests = append(s.requests, Request{Method: MethodNewSecretProvider, ProviderType: req.GetProviderType()})
+	err := s.initErr
+	s.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	return &sp.Empty{}, nil
+}
+
+// GetIAMToken ...
+func (s *Server) GetIAMToken(ctx context.Context, req *sp.Request) (*sp.IAMToken, error) {
+	s.mutex.Lock()
+	s.requests = append(s.requests, newRequest(MethodGetIAMToken, req))
+	response, ok := s.secretResponses[req.GetSecret()]
+	if !ok {
+		response = s.defaultResponse
+	}
+	s.mutex.Unlock()
+
+	return respond(ctx, response)
+}
+
+// GetDefaultIAMToken ...
+func (s *Server) GetDefaultIAMToken(ctx context.Context, req *sp.Request) (*sp.IAMToken, error) {
+	s.mutex.Lock()
+	s.requests = append(s.requests, newRequest(MethodGetDefaultIAMToken, req))
+	response := s.defaultResponse
+	s.mutex.Unlock()
+
+	return respond(ctx, response)
+}
+
+// newRequest ...
+func newRequest(method string, req *sp.Request) Request {
+	return Request{Method: method, Secret: req.GetSecret(), IsFreshTokenRequired: req.GetIsFreshTokenRequired(), ReasonForCall: req.GetReasonForCall()}
+}
+
+// respond waits for the latency of the response, and returns the token or the error set in it.
+func respond(ctx context.Context, response Response) (*sp.IAMToken, error) {
+	if response.Latency > 0 {
+		timer := time.NewTimer(response.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+
+	if response.Err != nil {
+		return nil, response.Err
+	}
+	return &sp.IAMToken{Iamtoken: response.Token, Tokenlifetime: response.TokenLifetime}, nil
+}
@@ -0,0 +1,158 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthChecker periodically checks the health of the sidecar and holds the result of the last check.
+type healthChecker struct {
+	mutex     sync.RWMutex
+	lastErr   error
+	checked   bool
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Healthy returns nil if the sidecar is serving. The grpc health protocol is used, if the sidecar does not implement it,
+// the sidecar is considered healthy if it can be connected to. The error returned can be checked using IsSidecarUnreachable.
+func (msp *ManagedSecretProvider) Healthy(ctx context.Context) error {
+	if _, err := msp.connect(ctx); err != nil {
+		return utils.Error{Description: localutils.ErrSidecarUnreachable, BackendError: err.Error()}
+	}
+
+	msp.connMutex.Lock()
+	conn := msp.conn
+	msp.connMutex.Unlock()
+	if conn == nil {
+		return utils.Error{Description: localutils.ErrSidecarUnreachable, BackendError: localutils.ErrSidecarConnectionClosed}
+	}
+
+	response, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+		// Sidecar does not implement the health protocol, connection being ready is considered healthy
+		return nil
+	case isUnavailable(err):
+		return utils.Error{Description: localutils.ErrSidecarUnreachable, BackendError: err.Error()}
+	case err != nil:
+		return utils.Error{Description: localutils.ErrSidecarUnhealthy, BackendError: err.Error()}
+	case response.GetStatus() != healthpb.HealthCheckResponse_SERVING:
+		return utils.Error{Description: localutils.ErrSidecarUnhealthy, BackendError: response.GetStatus().String()}
+	}
+	return nil
+}
+
+// HealthStatus returns the result of the last health check made by the background health checker, which is started
+// using WithSidecarHealthCheck. If the background health checker is not running, the health is checked now.
+func (msp *ManagedSecretProvider) HealthStatus() error {
+	if known, err := msp.healthState(); known {
+		return err
+	}
+
+	timeout := msp.retryPolicy.connectTimeout
+	if timeout <= 0 {
+		timeout = defaultSidecarConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return msp.Healthy(ctx)
+}
+
+// healthState returns the result of the last background health check, known is false if no check was made.
+func (msp *ManagedSecretProvider) healthState() (known bool, err error) {
+	msp.connMutex.Lock()
+	hc := msp.healthChecker
+	msp.connMutex.Unlock()
+	if hc == nil {
+		return false, nil
+	}
+
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	return hc.checked, hc.lastErr
+}
+
+// startHealthChecker checks the health of the sidecar every interval, until the secret provider is closed.
+func (msp *ManagedSecretProvider) startHealthChecker(interval time.Duration) {
+	hc := &healthChecker{stop: make(chan struct{}), done: make(chan struct{})}
+	msp.connMutex.Lock()
+	msp.healthChecker = hc
+	msp.connMutex.Unlock()
+
+	go func() {
+		defer close(hc.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			msp.checkHealth(hc, interval)
+			select {
+			case <-hc.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkHealth makes a health check and records its result, changes in health are logged.
+func (msp *ManagedSecretProvider) checkHealth(hc *healthChecker, interval time.Duration) {
+	timeout := msp.retryPolicy.connectTimeout
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := msp.Healthy(ctx)
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	switch {
+	case err != nil && (hc.lastErr == nil || !hc.checked):
+		msp.logger.Warn("Sidecar is unhealthy", zap.Error(err))
+	case err == nil && hc.lastErr != nil:
+		msp.logger.Info("Sidecar is healthy")
+	}
+	hc.lastErr = err
+	hc.checked = true
+}
+
+// stopHealthChecker stops the background health checker, if it is running.
+func (msp *ManagedSecretProvider) stopHealthChecker() {
+	msp.connMutex.Lock()
+	hc := msp.healthChecker
+	msp.healthChecker = nil
+	msp.connMutex.Unlock()
+	if hc == nil {
+		return
+	}
+
+	hc.closeOnce.Do(func() { close(hc.stop) })
+	<-hc.done
+}
@@ -0,0 +1,177 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	sp "github.com/IBM/secret-utils-lib/secretprovider"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultSidecarConnectTimeout is the time for which a call waits for the connection to sidecar in every attempt.
+	defaultSidecarConnectTimeout = 30 * time.Second
+
+	// defaultSidecarRetries is the number of times a call to sidecar is retried, if the sidecar is unreachable.
+	defaultSidecarRetries = 3
+
+	// defaultSidecarInitialBackoff ...
+	defaultSidecarInitialBackoff = time.Second
+
+	// defaultSidecarMaxBackoff ...
+	defaultSidecarMaxBackoff = 10 * time.Second
+
+	// failoverSidecarConnectTimeout is the connect timeout used by the failover secret provider, unless one is provided.
+	failoverSidecarConnectTimeout = 2 * time.Second
+
+	// failoverSidecarRetries ...
+	failoverSidecarRetries = 1
+
+	// failoverSidecarBackoff is both the initial and the maximum backoff used by the failover secret provider.
+	failoverSidecarBackoff = 200 * time.Millisecond
+)
+
+// sidecarRetryPolicy decides how long to wait for the sidecar and how many times to retry.
+type sidecarRetryPolicy struct {
+	connectTimeout time.Duration
+	retries        int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// defaultSidecarRetryPolicy ...
+func defaultSidecarRetryPolicy() sidecarRetryPolicy {
+	return sidecarRetryPolicy{
+		connectTimeout: defaultSidecarConnectTimeout,
+		retries:        defaultSidecarRetries,
+		initialBackoff: defaultSidecarInitialBackoff,
+		maxBackoff:     defaultSidecarMaxBackoff,
+	}
+}
+
+// failoverSidecarRetryPolicy is used by the failover secret provider, so that an unreachable sidecar is detected in a few
+// seconds, and the calls fall back to the unmanaged secret provider.
+func failoverSidecarRetryPolicy() sidecarRetryPolicy {
+	return sidecarRetryPolicy{
+		connectTimeout: failoverSidecarConnectTimeout,
+		retries:        failoverSidecarRetries,
+		initialBackoff: failoverSidecarBackoff,
+		maxBackoff:     failoverSidecarBackoff,
+	}
+}
+
+// backoff returns the time to wait before the given retry attempt (starting from 1), exponential with jitter.
+func (p sidecarRetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.initialBackoff
+	for i := 1; i < attempt && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	// Waiting for half of the backoff and a random duration within the other half, so that the callers do not retry together
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// callSidecar makes the given call to sidecar, it waits for the connection for the connect timeout and retries with backoff
+// if the sidecar is unreachable. The error returned can be checked using IsSidecarUnreachable.
+func (msp *ManagedSecretProvider) callSidecar(ctx context.Context, call func(ctx context.Context, c sp.SecretProviderClient) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt != 0 {
+			backoff := msp.retryPolicy.backoff(attempt)
+			msp.logger.Warn("Unable to reach sidecar, retrying", zap.Error(err), zap.Int("attempt", attempt), zap.Duration("backoff", backoff))
+			select {
+			case <-ctx.Done():
+				return utils.Error{Description: localutils.ErrSidecarUnreachable, BackendError: err.Error()}
+			case <-time.After(backoff):
+			}
+		}
+
+		var c sp.SecretProviderClient
+		c, err = msp.connect(ctx)
+		if err == nil {
+			err = call(ctx, c)
+			if err == nil {
+				return nil
+			}
+			if !isUnavailable(err) {
+				return err
+			}
+		}
+
+		if attempt >= msp.retryPolicy.retries || ctx.Err() != nil {
+			return utils.Error{Description: localutils.ErrSidecarUnreachable, BackendError: err.Error()}
+		}
+	}
+}
+
+// connect waits for the connection to sidecar to be ready, for the connect timeout.
+func (msp *ManagedSecretProvider) connect(ctx context.Context) (sp.SecretProviderClient, error) {
+	c, err := msp.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	connectCtx := ctx
+	if msp.retryPolicy.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, msp.retryPolicy.connectTimeout)
+		defer cancel()
+	}
+
+	if err = msp.waitForConnection(connectCtx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// isUnavailable returns true if the call failed because the sidecar could not be reached.
+func isUnavailable(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// sidecarError returns the error as is if the sidecar was unreachable, else the error returned by the sidecar is wrapped.
+func sidecarError(err error) error {
+	if IsSidecarUnreachable(err) {
+		return err
+	}
+	if s, ok := status.FromError(err); ok {
+		return utils.Error{Description: localutils.ErrFetchingTokenFromSidecar, BackendError: s.Message()}
+	}
+	return utils.Error{Description: localutils.ErrFetchingTokenFromSidecar, BackendError: err.Error()}
+}
+
+// IsSidecarUnreachable returns true if the error was returned because the secret sidecar could not be reached,
+// as opposed to the sidecar failing to fetch the token from IAM.
+func IsSidecarUnreachable(err error) bool {
+	var e utils.Error
+	return errors.As(err, &e) && e.Description == localutils.ErrSidecarUnreachable
+}
@@ -0,0 +1,182 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSecretCacheLimit is the number of secrets, other than the default secret, whose tokens are cached.
+	DefaultSecretCacheLimit = 10
+)
+
+// cachedToken holds an IAM token along with the time at which it expires, the zero value is an empty cache.
+type cachedToken struct {
+	mutex  sync.RWMutex
+	token  string
+	expiry time.Time
+}
+
+// get returns the token and its remaining lifetime in seconds, if it is valid for longer than expiryDiff.
+func (ct *cachedToken) get(expiryDiff time.Duration) (string, uint64, bool) {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+
+	remaining := time.Until(ct.expiry)
+	if ct.token == "" || remaining <= expiryDiff {
+		return "", 0, false
+	}
+	return ct.token, uint64(remaining / time.Second), true
+}
+
+// set ...
+func (ct *cachedToken) set(token string, tokenlifetime uint64) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	ct.token = token
+	ct.expiry = time.Now().Add(time.Duration(tokenlifetime) * time.Second)
+}
+
+// clear ...
+func (ct *cachedToken) clear() {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	ct.token = ""
+	ct.expiry = time.Time{}
+}
+
+// tokenFetcher is implemented by the authenticators of secret-utils-lib and by containerAuthenticator.
+type tokenFetcher interface {
+	GetToken(freshTokenRequired bool) (string, uint64, error)
+}
+
+// contextTokenFetcher is implemented by containerAuthenticator, which stops retrying the request once ctx is done.
+type contextTokenFetcher interface {
+	GetTokenContext(ctx context.Context, freshTokenRequired bool) (string, uint64, error)
+}
+
+// fetchToken fetches a fresh token using the given authenticator, bound to ctx if the authenticator accepts it.
+func fetchToken(ctx context.Context, authenticator tokenFetcher) (string, uint64, error) {
+	if fetcher, ok := authenticator.(contextTokenFetcher); ok {
+		return fetcher.GetTokenContext(ctx, true)
+	}
+	return authenticator.GetToken(true)
+}
+
+// secretCacheEntry holds the authenticator and the token of a secret.
+type secretCacheEntry struct {
+	key           string
+	authType      string
+	authenticator tokenFetcher
+
+	// fetchMutex serialises fetching the token of the secret, the concurrent callers share a single fetch using flight
+	fetchMutex sync.Mutex
+	flight     tokenFlight
+	token      cachedToken
+}
+
+// secretCache holds the authenticators and tokens of up to limit secrets or credentials, keyed by their hash.
+// The least recently used secret is removed when the limit is reached.
+type secretCache struct {
+	mutex   sync.Mutex
+	limit   int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// newSecretCache ...
+func newSecretCache(limit int) *secretCache {
+	return &secretCache{limit: limit, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the entry of the given key, marking it as the most recently used. If the key is not cached, or it
+// was cached for a different auth type, a new entry is added using the given authenticator.
+func (sc *secretCache) get(key, authType string, newAuthenticator func() tokenFetcher) *secretCacheEntry {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	if element, ok := sc.entries[key]; ok {
+		entry := element.Value.(*secretCacheEntry)
+		if entry.authType == authType {
+			sc.lru.MoveToFront(element)
+			return entry
+		}
+		sc.lru.Remove(element)
+		delete(sc.entries, key)
+	}
+
+	entry := &secretCacheEntry{key: key, authType: authType, authenticator: newAuthenticator()}
+	sc.entries[key] = sc.lru.PushFront(entry)
+	if sc.lru.Len() > sc.limit {
+		oldest := sc.lru.Back()
+		sc.lru.Remove(oldest)
+		delete(sc.entries, oldest.Value.(*secretCacheEntry).key)
+	}
+	return entry
+}
+
+// clear removes all the secrets, so that their authenticators are created again.
+func (sc *secretCache) clear() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.lru.Init()
+	sc.entries = make(map[string]*list.Element)
+}
+
+// getToken returns the cached token if it is valid for longer than expiryDiff and a fresh token is not required,
+// else fetches a fresh token, returning as soon as ctx is done. The concurrent callers share a single fetch.
+func (entry *secretCacheEntry) getToken(ctx context.Context, isFreshTokenRequired bool, expiryDiff time.Duration) (string, uint64, error) {
+	if !isFreshTokenRequired {
+		if token, tokenlifetime, ok := entry.token.get(expiryDiff); ok {
+			return token, tokenlifetime, nil
+		}
+	}
+
+	return entry.flight.do(ctx, flightKey(entry.key, isFreshTokenRequired), func(ctx context.Context) (string, uint64, error) {
+		entry.fetchMutex.Lock()
+		defer entry.fetchMutex.Unlock()
+
+		// The token may have been fetched by a concurrent call, while this one was waiting
+		if !isFreshTokenRequired {
+			if token, tokenlifetime, ok := entry.token.get(expiryDiff); ok {
+				return token, tokenlifetime, nil
+			}
+		}
+
+		token, tokenlifetime, err := fetchToken(ctx, entry.authenticator)
+		if err != nil {
+			return token, tokenlifetime, err
+		}
+		entry.token.set(token, tokenlifetime)
+		return token, tokenlifetime, nil
+	})
+}
+
+// hashSecret returns the key under which the secret is cached, so that the secret itself is not held as a key.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
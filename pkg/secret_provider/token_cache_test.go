@@ -0,0 +1,387 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeTokenFetcher returns a new token on every fetch, with the given lifetime, or the given error.
+type fakeTokenFetcher struct {
+	mutex    sync.Mutex
+	lifetime uint64
+	err      error
+	fetches  int
+}
+
+// GetToken ...
+func (ff *fakeTokenFetcher) GetToken(freshTokenRequired bool) (string, uint64, error) {
+	ff.mutex.Lock()
+	defer ff.mutex.Unlock()
+	ff.fetches++
+	if ff.err != nil {
+		return "", 0, ff.err
+	}
+	return fmt.Sprintf("token-%d", ff.fetches), ff.lifetime, nil
+}
+
+// fetchCount ...
+func (ff *fakeTokenFetcher) fetchCount() int {
+	ff.mutex.Lock()
+	defer ff.mutex.Unlock()
+	return ff.fetches
+}
+
+func TestCachedToken(t *testing.T) {
+	testCases := []struct {
+		name       string
+		lifetime   uint64
+		expiryDiff time.Duration
+		set        bool
+		clear      bool
+		expectHit  bool
+	}{
+		{name: "empty", expiryDiff: DefaultTokenExpiryDiff},
+		{name: "valid", lifetime: 3600, expiryDiff: DefaultTokenExpiryDiff, set: true, expectHit: true},
+		{name: "valid without expiry diff", lifetime: 60, set: true, expectHit: true},
+		{name: "expires within expiry diff", lifetime: 200, expiryDiff: DefaultTokenExpiryDiff, set: true},
+		{name: "expired", lifetime: 0, set: true},
+		{name: "cleared", lifetime: 3600, expiryDiff: DefaultTokenExpiryDiff, set: true, clear: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var ct cachedToken
+			if tc.set {
+				ct.set("token", tc.lifetime)
+			}
+			if tc.clear {
+				ct.clear()
+			}
+
+			token, tokenlifetime, ok := ct.get(tc.expiryDiff)
+			if ok != tc.expectHit {
+				t.Fatalf("Expected cache hit %v, got %v", tc.expectHit, ok)
+			}
+			if !ok {
+				return
+			}
+			if token != "token" || tokenlifetime > tc.lifetime || tokenlifetime < tc.lifetime-1 {
+				t.Errorf("Expected the token with lifetime %d, got %q with lifetime %d", tc.lifetime, token, tokenlifetime)
+			}
+		})
+	}
+}
+
+func TestSecretCacheEntryGetToken(t *testing.T) {
+	testCases := []struct {
+		name          string
+		lifetime      uint64
+		err           error
+		freshRequired []bool
+		expectFetches int
+		expectSame    bool
+	}{
+		{name: "cache hit", lifetime: 3600, freshRequired: []bool{false, false, false}, expectFetches: 1, expectSame: true},
+		{name: "fresh token required", lifetime: 3600, freshRequired: []bool{false, true}, expectFetches: 2},
+		{name: "cached after fresh token", lifetime: 3600, freshRequired: []bool{true, false}, expectFetches: 1, expectSame: true},
+		{name: "expires within expiry diff", lifetime: 60, freshRequired: []bool{false, false}, expectFetches: 2},
+		{name: "error is not cached", err: errors.New("iam unavailable"), freshRequired: []bool{false, false}, expectFetches: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fetcher := &fakeTokenFetcher{lifetime: tc.lifetime, err: tc.err}
+			entry := newSecretCache(DefaultSecretCacheLimit).get(hashSecret("secret"), utils.DEFAULT, func() tokenFetcher { return fetcher })
+
+			var tokens []string
+			for _, freshRequired := range tc.freshRequired {
+				token, _, err := entry.getToken(context.Background(), freshRequired, DefaultTokenExpiryDiff)
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("Expected error %v, got %v", tc.err, err)
+				}
+				tokens = append(tokens, token)
+			}
+
+			if n := fetcher.fetchCount(); n != tc.expectFetches {
+				t.Errorf("Expected %d fetches, got %d", tc.expectFetches, n)
+			}
+			if tc.err == nil && (tokens[0] == tokens[len(tokens)-1]) != tc.expectSame {
+				t.Errorf("Expected the same token %v, got %v", tc.expectSame, tokens)
+			}
+		})
+	}
+}
+
+func TestDefaultTokenCache(t *testing.T) {
+	testCases := []struct {
+		name          string
+		lifetime      time.Duration
+		reload        bool
+		expectFetches int
+	}{
+		{name: "cache hit", lifetime: time.Hour, expectFetches: 1},
+		{name: "expires within expiry diff", lifetime: 4 * time.Minute, expectFetches: 2},
+		{name: "invalidated on secret reload", lifetime: time.Hour, reload: true, expectFetches: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeIAMServer(t)
+			server.setResponse(tc.lifetime, 200, 0)
+			kc := newFakeK8sClient(t, server.URL)
+			usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t))
+			if err != nil {
+				t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+			}
+
+			token, _, err := usp.GetDefaultIAMToken(false)
+			if err != nil {
+				t.Fatalf("Unable to fetch token: %v", err)
+			}
+			if tc.reload {
+				usp.reloadSecret(utils.IBMCLOUD_CREDENTIALS_SECRET, "updated")
+			}
+			next, _, err := usp.GetDefaultIAMToken(false)
+			if err != nil {
+				t.Fatalf("Unable to fetch token: %v", err)
+			}
+
+			if n := len(server.requests()); n != tc.expectFetches {
+				t.Errorf("Expected %d requests to IAM, got %d", tc.expectFetches, n)
+			}
+			if (next == token) != (tc.expectFetches == 1) {
+				t.Errorf("Expected the cached token only when a single request is made, got %q and %q", token, next)
+			}
+		})
+	}
+}
+
+func TestSecretCache(t *testing.T) {
+	type access struct {
+		key       string
+		authType  string
+		expectHit bool
+	}
+	testCases := []struct {
+		name     string
+		limit    int
+		accesses []access
+	}{
+		{
+			name:  "cached by key",
+			limit: 2,
+			accesses: []access{
+				{key: "a", authType: utils.IAM},
+				{key: "a", authType: utils.IAM, expectHit: true},
+				{key: "b", authType: utils.IAM},
+				{key: "a", authType: utils.IAM, expectHit: true},
+			},
+		},
+		{
+			name:  "cached by auth type",
+			limit: 2,
+			accesses: []access{
+				{key: "a", authType: utils.IAM},
+				{key: "a", authType: utils.PODIDENTITY},
+				{key: "a", authType: utils.PODIDENTITY, expectHit: true},
+				{key: "a", authType: utils.IAM},
+			},
+		},
+		{
+			name:  "least recently used is evicted",
+			limit: 2,
+			accesses: []access{
+				{key: "a", authType: utils.IAM},
+				{key: "b", authType: utils.IAM},
+				{key: "a", authType: utils.IAM, expectHit: true},
+				{key: "c", authType: utils.IAM},
+				{key: "a", authType: utils.IAM, expectHit: true},
+				{key: "b", authType: utils.IAM},
+				{key: "c", authType: utils.IAM},
+			},
+		},
+		{
+			name:  "limit of one",
+			limit: 1,
+			accesses: []access{
+				{key: "a", authType: utils.IAM},
+				{key: "a", authType: utils.IAM, expectHit: true},
+				{key: "b", authType: utils.IAM},
+				{key: "a", authType: utils.IAM},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := newSecretCache(tc.limit)
+			for i, a := range tc.accesses {
+				created := false
+				sc.get(a.key, a.authType, func() tokenFetcher {
+					created = true
+					return &fakeTokenFetcher{lifetime: 3600}
+				})
+				if created == a.expectHit {
+					t.Errorf("Access %d of %s with %s: expected cache hit %v", i, a.key, a.authType, a.expectHit)
+				}
+				if n := sc.lru.Len(); n > tc.limit || n != len(sc.entries) {
+					t.Fatalf("Expected at most %d entries, got %d in the list and %d in the map", tc.limit, n, len(sc.entries))
+				}
+			}
+
+			sc.clear()
+			if sc.lru.Len() != 0 || len(sc.entries) != 0 {
+				t.Errorf("Expected no entries after clear, got %d", sc.lru.Len())
+			}
+		})
+	}
+}
+
+func TestGetIAMTokenSecretCache(t *testing.T) {
+	testCases := []struct {
+		name           string
+		secrets        []string
+		expectRequests int
+	}{
+		{name: "cache hit", secrets: []string{"key-1", "key-1", "key-1"}, expectRequests: 1},
+		{name: "secrets cached separately", secrets: []string{"key-1", "key-2", "key-1", "key-2"}, expectRequests: 2},
+		{name: "least recently used is evicted", secrets: []string{"key-1", "key-2", "key-3", "key-1"}, expectRequests: 4},
+		{name: "recently used is kept", secrets: []string{"key-1", "key-2", "key-1", "key-3", "key-1"}, expectRequests: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeIAMServer(t)
+			kc := newFakeK8sClient(t, server.URL)
+			usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t, WithSecretCacheLimit(2)))
+			if err != nil {
+				t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+			}
+
+			for _, secret := range tc.secrets {
+				if _, _, err := usp.GetIAMToken(secret, false); err != nil {
+					t.Fatalf("Unable to fetch token: %v", err)
+				}
+			}
+			requests := server.requests()
+			if len(requests) != tc.expectRequests {
+				t.Errorf("Expected %d requests to IAM, got %d", tc.expectRequests, len(requests))
+			}
+
+			// The secrets are held hashed
+			last := tc.secrets[len(tc.secrets)-1]
+			if _, ok := usp.secretCache.entries[hashSecret(last)]; !ok {
+				t.Errorf("Expected %s to be cached by its hash", last)
+			}
+			for _, secret := range tc.secrets {
+				if _, ok := usp.secretCache.entries[secret]; ok {
+					t.Errorf("Expected %s not to be held as a key", secret)
+				}
+			}
+		})
+	}
+}
+
+func TestSecretCacheClearedOnConfigReload(t *testing.T) {
+	testCases := []struct {
+		name       string
+		managed    bool
+		urlChanged bool
+	}{
+		{name: "unmanaged url changed", urlChanged: true},
+		{name: "unmanaged url unchanged"},
+		{name: "managed url changed", managed: true, urlChanged: true},
+		{name: "managed url unchanged", managed: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, changedServer := newFakeIAMServer(t), newFakeIAMServer(t)
+			kc := newFakeK8sClient(t, server.URL)
+			opts := newTestProviderOptions(t)
+			credential := Credential{Type: APIKey, APIKey: "tenant-api-key"}
+
+			var getToken func() error
+			var reloadConfig func(reader configReader)
+			if tc.managed {
+				msp, err := initManagedSecretProvider(context.Background(), &kc, zap.NewNop(), opts)
+				if err != nil {
+					t.Fatalf("Unable to initialise managed secret provider: %v", err)
+				}
+				getToken = func() error {
+					_, _, err := msp.GetIAMTokenForCredential(context.Background(), credential, false)
+					return err
+				}
+				reloadConfig = msp.reloadConfig
+			} else {
+				usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), opts)
+				if err != nil {
+					t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+				}
+				getToken = func() error {
+					_, _, err := usp.GetIAMToken(credential.APIKey, false)
+					return err
+				}
+				reloadConfig = usp.reloadConfig
+			}
+
+			if err := getToken(); err != nil {
+				t.Fatalf("Unable to fetch token: %v", err)
+			}
+			tokenExchangeURL, region := server.URL, "eu-de"
+			if tc.urlChanged {
+				tokenExchangeURL = changedServer.URL
+			}
+			setCloudConf(t, kc, region, tokenExchangeURL)
+			reloadConfig(apiConfigReader{k8sClient: kc})
+			if err := getToken(); err != nil {
+				t.Fatalf("Unable to fetch token: %v", err)
+			}
+
+			// The cached token is used until the token exchange URL changes, the token is then fetched from the new URL
+			expectRequests, expectChangedRequests := 1, 0
+			if tc.urlChanged {
+				expectChangedRequests = 1
+			}
+			if n, changed := len(server.requests()), len(changedServer.requests()); n != expectRequests || changed != expectChangedRequests {
+				t.Errorf("Expected %d requests to the previous URL and %d to the new one, got %d and %d", expectRequests, expectChangedRequests, n, changed)
+			}
+		})
+	}
+}
+
+// setCloudConf updates cloud-conf with the given region and token exchange URL.
+func setCloudConf(t *testing.T, kc k8s_utils.KubernetesClient, region, tokenExchangeURL string) {
+	t.Helper()
+	cloudConf := fmt.Sprintf(`{"region": %q, "riaas_endpoint": "https://%[1]s.iaas.cloud.ibm.com", "token_exchange_url": %q}`, region, tokenExchangeURL)
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cloud-conf", Namespace: kc.Namespace}, Data: map[string]string{"cloud-conf.json": cloudConf}}
+	if _, err := kc.Clientset.CoreV1().ConfigMaps(kc.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Unable to update cloud-conf: %v", err)
+	}
+}
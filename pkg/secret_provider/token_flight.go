@@ -0,0 +1,109 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+const (
+	// defaultFlightKey is the key of the token fetches of the default secret.
+	defaultFlightKey = "default"
+
+	// tokenFetchTimeout is the timeout of a shared token fetch, it is kept same as the timeout of the calls to sidecar.
+	tokenFetchTimeout = sidecarCallTimeout
+)
+
+// tokenFlight coalesces the concurrent token fetches of a key, so that the callers share the result of a single fetch.
+// The zero value is ready to use.
+type tokenFlight struct {
+	mutex sync.Mutex
+	calls map[string]*tokenCall
+}
+
+// tokenCall is a token fetch in flight, along with the number of callers waiting for it.
+type tokenCall struct {
+	done    chan struct{}
+	waiters int
+	cancel  context.CancelFunc
+
+	token         string
+	tokenlifetime uint64
+	err           error
+}
+
+// do runs the given fetch, unless a fetch of the same key is in flight, in which case its result is returned.
+// It returns as soon as ctx is done. The context passed to the fetch carries the values of the caller which started it,
+// but not its deadline or cancellation, so that the callers waiting with a longer deadline are not cut short. It expires
+// after tokenFetchTimeout, and is cancelled once all its callers have returned on their context being done.
+// The authenticators do not accept a context, hence their abandoned fetches complete in the background.
+func (tf *tokenFlight) do(ctx context.Context, key string, fetch func(ctx context.Context) (string, uint64, error)) (string, uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+
+	tf.mutex.Lock()
+	if tf.calls == nil {
+		tf.calls = make(map[string]*tokenCall)
+	}
+	call, ok := tf.calls[key]
+	if !ok {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenFetchTimeout)
+		call = &tokenCall{done: make(chan struct{}), cancel: cancel}
+		tf.calls[key] = call
+		go func() {
+			call.token, call.tokenlifetime, call.err = fetch(fetchCtx)
+			tf.mutex.Lock()
+			tf.forget(key, call)
+			tf.mutex.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	tf.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.tokenlifetime, call.err
+	case <-ctx.Done():
+		tf.mutex.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// The abandoned fetch is not shared with the later callers, since its context is cancelled
+			tf.forget(key, call)
+			call.cancel()
+		}
+		tf.mutex.Unlock()
+		return "", 0, ctx.Err()
+	}
+}
+
+// forget removes the given call of the key, if it is not replaced already, tf.mutex must be held.
+func (tf *tokenFlight) forget(key string, call *tokenCall) {
+	if tf.calls[key] == call {
+		delete(tf.calls, key)
+	}
+}
+
+// flightKey returns the key of a token fetch, a fetch of a fresh token is not shared with the callers which accept
+// a cached token and vice versa.
+func flightKey(key string, freshTokenRequired bool) string {
+	return key + "/" + strconv.FormatBool(freshTokenRequired)
+}
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenFlightMixedDeadlines(t *testing.T) {
+	var tf tokenFlight
+	var fetches int32
+	started := make(chan struct{})
+	fetch := func(ctx context.Context) (string, uint64, error) {
+		atomic.AddInt32(&fetches, 1)
+		close(started)
+		select {
+		case <-time.After(200 * time.Millisecond):
+			return "token", 3600, nil
+		case <-ctx.Done():
+			return "", 0, ctx.Err()
+		}
+	}
+
+	shortCtx, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	longCtx, cancelLong := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelLong()
+
+	var wg sync.WaitGroup
+	var shortErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _, shortErr = tf.do(shortCtx, "key", fetch)
+	}()
+	<-started
+
+	token, tokenlifetime, err := tf.do(longCtx, "key", fetch)
+	wg.Wait()
+
+	if !errors.Is(shortErr, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded for the short caller, got %v", shortErr)
+	}
+	if err != nil || token != "token" || tokenlifetime != 3600 {
+		t.Errorf("Expected the token for the long caller, got %q, %d, %v", token, tokenlifetime, err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("Expected a single fetch, got %d", n)
+	}
+}
+
+func TestTokenFlightCancelledWithoutWaiters(t *testing.T) {
+	var tf tokenFlight
+	fetchCtx := make(chan context.Context, 1)
+	fetch := func(ctx context.Context) (string, uint64, error) {
+		fetchCtx <- ctx
+		<-ctx.Done()
+		return "", 0, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := tf.do(ctx, "key", fetch)
+		done <- err
+	}()
+
+	fctx := <-fetchCtx
+	if _, ok := fctx.Deadline(); !ok {
+		t.Error("Expected the fetch to have a deadline")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context canceled, got %v", err)
+	}
+
+	select {
+	case <-fctx.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected the fetch to be cancelled once no caller is waiting")
+	}
+}
+
+func TestTokenFlightSharedResult(t *testing.T) {
+	testCases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "token", token: "token"},
+		{name: "error", err: errors.New("fetch failed")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var tf tokenFlight
+			release := make(chan struct{})
+			fetch := func(context.Context) (string, uint64, error) {
+				<-release
+				return tc.token, 3600, tc.err
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 10)
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					token, _, err := tf.do(context.Background(), "key", fetch)
+					if token != tc.token || !errors.Is(err, tc.err) {
+						errs <- errors.New("unexpected result " + token)
+					}
+				}()
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// tokenRefreshJitter is the fraction of the refresh interval by which the refresh is made earlier at random,
+	// so that the replicas of an application do not refresh together.
+	tokenRefreshJitter = 0.1
+
+	// tokenRefreshInitialBackoff is the time after which a failed refresh is retried, it doubles with every failure.
+	tokenRefreshInitialBackoff = time.Second
+
+	// tokenRefreshMaxBackoff ...
+	tokenRefreshMaxBackoff = time.Minute
+)
+
+// tokenRefresher refreshes the token of the default secret in the background and holds the result of the last refresh.
+type tokenRefresher struct {
+	fraction  float64
+	mutex     sync.RWMutex
+	lastErr   error
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// LastRefreshError returns the error seen in the last refresh made by the background token refresher, which is started
+// using WithTokenRefresh. Nil is returned if the last refresh succeeded, or if the refresher is not running.
+func (usp *UnmanagedSecretProvider) LastRefreshError() error {
+	usp.backgroundMutex.Lock()
+	tr := usp.tokenRefresher
+	usp.backgroundMutex.Unlock()
+	if tr == nil {
+		return nil
+	}
+
+	tr.mutex.RLock()
+	defer tr.mutex.RUnlock()
+	return tr.lastErr
+}
+
+// stopTokenRefresher stops the background token refresher, if it is running.
+func (usp *UnmanagedSecretProvider) stopTokenRefresher() {
+	usp.backgroundMutex.Lock()
+	tr := usp.tokenRefresher
+	usp.tokenRefresher = nil
+	usp.backgroundMutex.Unlock()
+	if tr == nil {
+		return
+	}
+
+	tr.closeOnce.Do(func() { close(tr.stop) })
+	<-tr.done
+}
+
+// startTokenRefresher fetches the token of the default secret now, and thereafter when the given fraction of its lifetime has passed.
+func (usp *UnmanagedSecretProvider) startTokenRefresher(fraction float64) {
+	tr := &tokenRefresher{fraction: fraction, stop: make(chan struct{}), done: make(chan struct{})}
+	usp.backgroundMutex.Lock()
+	usp.tokenRefresher = tr
+	usp.backgroundMutex.Unlock()
+
+	go func() {
+		defer close(tr.done)
+		failures := 0
+		for {
+			wait := usp.refreshToken(tr)
+			if wait == 0 {
+				failures++
+				wait = refreshBackoff(failures)
+			} else {
+				failures = 0
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-tr.stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// refreshToken fetches a fresh token and records the result, it returns the time after which the token needs to be
+// refreshed again, or zero if the refresh failed.
+func (usp *UnmanagedSecretProvider) refreshToken(tr *tokenRefresher) time.Duration {
+	_, tokenlifetime, err := usp.fetchDefaultToken(true)
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if err != nil {
+		if tr.lastErr == nil {
+			usp.logger.Warn("Unable to refresh IAM token", zap.Error(err))
+		}
+		tr.lastErr = err
+		return 0
+	}
+	if tr.lastErr != nil {
+		usp.logger.Info("Refreshed IAM token")
+	}
+	tr.lastErr = nil
+
+	interval := time.Duration(float64(tokenlifetime) * tr.fraction * float64(time.Second))
+	interval -= time.Duration(rand.Float64() * tokenRefreshJitter * float64(interval))
+	if interval < tokenRefreshInitialBackoff {
+		interval = tokenRefreshInitialBackoff
+	}
+	usp.logger.Debug("Refreshed IAM token", zap.Uint64("token-life-time-in-seconds", tokenlifetime), zap.Duration("next-refresh", interval))
+	return interval
+}
+
+// refreshBackoff returns the time to wait before retrying after the given number of failed refreshes.
+func refreshBackoff(failures int) time.Duration {
+	backoff := tokenRefreshInitialBackoff
+	for i := 1; i < failures && backoff < tokenRefreshMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > tokenRefreshMaxBackoff {
+		backoff = tokenRefreshMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,158 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secret_provider
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRefreshBackoff(t *testing.T) {
+	testCases := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 1, expected: time.Second},
+		{failures: 2, expected: 2 * time.Second},
+		{failures: 3, expected: 4 * time.Second},
+		{failures: 6, expected: 32 * time.Second},
+		{failures: 7, expected: tokenRefreshMaxBackoff},
+		{failures: 100, expected: tokenRefreshMaxBackoff},
+	}
+
+	for _, tc := range testCases {
+		if backoff := refreshBackoff(tc.failures); backoff != tc.expected {
+			t.Errorf("Expected backoff %v after %d failures, got %v", tc.expected, tc.failures, backoff)
+		}
+	}
+}
+
+func TestRefreshTokenInterval(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lifetime time.Duration
+		fraction float64
+		min      time.Duration
+		max      time.Duration
+	}{
+		{name: "fraction of lifetime", lifetime: time.Hour, fraction: 0.8, min: time.Duration(0.8 * (1 - tokenRefreshJitter) * float64(time.Hour-time.Second)), max: time.Duration(0.8 * float64(time.Hour))},
+		{name: "small fraction", lifetime: time.Hour, fraction: 0.1, min: time.Duration(0.1 * (1 - tokenRefreshJitter) * float64(time.Hour-time.Second)), max: time.Duration(0.1 * float64(time.Hour))},
+		{name: "not sooner than initial backoff", lifetime: time.Hour, fraction: 0.0001, min: tokenRefreshInitialBackoff, max: tokenRefreshInitialBackoff},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeIAMServer(t)
+			server.setResponse(tc.lifetime, http.StatusOK, 0)
+			kc := newFakeK8sClient(t, server.URL)
+			usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t))
+			if err != nil {
+				t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+			}
+
+			tr := &tokenRefresher{fraction: tc.fraction}
+			distinct := make(map[time.Duration]bool)
+			for i := 0; i < 20; i++ {
+				interval := usp.refreshToken(tr)
+				if interval < tc.min || interval > tc.max {
+					t.Fatalf("Expected refresh interval between %v and %v, got %v", tc.min, tc.max, interval)
+				}
+				distinct[interval] = true
+			}
+			if tc.min != tc.max && len(distinct) == 1 {
+				t.Error("Expected the refresh interval to be jittered")
+			}
+			if n := len(server.requests()); n != 20 {
+				t.Errorf("Expected every refresh to fetch a fresh token, got %d requests for 20 refreshes", n)
+			}
+		})
+	}
+}
+
+func TestTokenRefresher(t *testing.T) {
+	server := newFakeIAMServer(t)
+	server.setResponse(time.Hour, http.StatusInternalServerError, 0)
+	kc := newFakeK8sClient(t, server.URL)
+	start := time.Now()
+	usp, err := newUnmanagedSecretProvider(&kc, zap.NewNop(), newTestProviderOptions(t, WithTokenRefresh(0.8)))
+	if err != nil {
+		t.Fatalf("Unable to initialise unmanaged secret provider: %v", err)
+	}
+	defer usp.Close()
+
+	// The failed refresh is reported, and retried after the initial backoff.
+	waitForRequests(t, server, 1)
+	if err := usp.LastRefreshError(); err == nil {
+		t.Error("Expected the error of the failed refresh to be reported")
+	}
+	waitForRequests(t, server, 2)
+	if elapsed := time.Since(start); elapsed < tokenRefreshInitialBackoff {
+		t.Errorf("Expected the failed refresh to be retried after %v, retried after %v", tokenRefreshInitialBackoff, elapsed)
+	}
+
+	// The next retry is backed off further, and clears the error once it succeeds.
+	retried := time.Now()
+	server.setResponse(time.Hour, http.StatusOK, 0)
+	waitForRequests(t, server, 3)
+	if elapsed := time.Since(retried); elapsed < 2*tokenRefreshInitialBackoff-50*time.Millisecond {
+		t.Errorf("Expected the backoff to double after the second failure, retried after %v", elapsed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for usp.LastRefreshError() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the error to be cleared by the successful refresh, got %v", usp.LastRefreshError())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The refreshed token is served from the cache.
+	if _, _, err := usp.GetDefaultIAMToken(false); err != nil {
+		t.Fatalf("Unable to fetch token: %v", err)
+	}
+	if n := len(server.requests()); n != 3 {
+		t.Errorf("Expected the refreshed token to be cached, got %d requests", n)
+	}
+
+	// Close stops the refresher goroutine.
+	usp.backgroundMutex.Lock()
+	tr := usp.tokenRefresher
+	usp.backgroundMutex.Unlock()
+	usp.Close()
+	select {
+	case <-tr.done:
+	default:
+		t.Fatal("Expected the token refresher to be stopped by Close")
+	}
+	if err := usp.LastRefreshError(); err != nil {
+		t.Errorf("Expected no error once the token refresher is stopped, got %v", err)
+	}
+}
+
+// waitForRequests waits until the IAM server has received the given number of requests.
+func waitForRequests(t *testing.T, server *fakeIAMServer, count int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.requests()) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d requests to IAM, got %d", count, len(server.requests()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
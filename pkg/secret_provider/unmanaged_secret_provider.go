@@ -17,9 +17,14 @@
 package secret_provider
 
 import (
+	"context"
 	"encoding/base64"
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	localutils "github.com/IBM/secret-common-lib/pkg/utils"
 	auth "github.com/IBM/secret-utils-lib/pkg/authenticator"
@@ -30,83 +35,138 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// DefaultTokenExpiryDiff is the minimum validity of the token returned, a fresh token is fetched if the cached one expires sooner.
+	// It is also the default of the sidecar.
+	DefaultTokenExpiryDiff = 5 * time.Minute
+
+	// TokenExpiryDiffEnv is the environment variable which can hold the minimum validity of the token returned, such as 20m.
+	// It is read by both the unmanaged secret provider and the sidecar.
+	TokenExpiryDiffEnv = "TOKEN_EXPIRY_DIFF"
+
+	// SecretCacheLimitEnv is the environment variable which can hold the number of secrets whose tokens are cached.
+	SecretCacheLimitEnv = "SECRET_CACHE_LIMIT"
+)
+
 // UnmanagedSecretProvider ...
 type UnmanagedSecretProvider struct {
-	authenticator            auth.Authenticator
 	logger                   *zap.Logger
 	k8sClient                k8s_utils.KubernetesClient
-	authType                 string
+	authArgs                 []map[string]string
 	tokenExchangeURL         string
 	region                   string
-	riaasEndpoint            string
-	privateRIAASEndpoint     string
-	containerAPIRoute        string
-	privateContainerAPIRoute string
+	endpoints                *endpointRegistry
 	resourceGroupID          string
 	providedTokenExchangeURL bool
+	tokenExpiryDiff          time.Duration
+
+	// configMutex guards the token exchange URL, region and resource group ID above, which are replaced by the config
+	// watcher once the secret provider is initialised
+	configMutex sync.RWMutex
+
+	// authMutex guards the authenticator and the auth type below, which are replaced when the secret is updated.
+	// Replacing them also holds tokenMutex, hence they can be read without authMutex while holding tokenMutex.
+	authMutex     sync.RWMutex
+	authenticator auth.Authenticator
+	authType      string
+
+	// tokenMutex serialises fetching the token of the default secret.
+	tokenMutex sync.Mutex
+
+	defaultToken cachedToken
+	tokenFlight  tokenFlight
+
+	// secretCache holds the authenticators and tokens of the secrets provided in GetIAMToken and the credentials
+	// provided in GetIAMTokenForCredential
+	secretCache *secretCache
+
+	// backgroundMutex guards tokenRefresher and secretWatcher, which are set if they are running
+	backgroundMutex sync.Mutex
+	tokenRefresher  *tokenRefresher
+	secretWatcher   *secretWatcher
+
+	subscribers subscribers
 }
 
 // newUnmanagedSecretProvider ...
-func newUnmanagedSecretProvider(k8sClient *k8s_utils.KubernetesClient, logger *zap.Logger, optionalArgs ...map[string]string) (*UnmanagedSecretProvider, error) {
+func newUnmanagedSecretProvider(k8sClient *k8s_utils.KubernetesClient, logger *zap.Logger, opts *providerOptions) (*UnmanagedSecretProvider, error) {
+	var kc k8s_utils.KubernetesClient
+	if k8sClient != nil {
+		kc = *k8sClient
+	}
+	if opts.namespace != "" {
+		kc.Namespace = opts.namespace
+	}
+
 	// Validate the argument k8s client
 	validate := validator.New()
-	err := validate.Struct(k8sClient)
+	err := validate.Struct(kc)
 	if err != nil {
 		logger.Error("Provided k8s client is invalid", zap.Error(err))
 		return nil, utils.Error{Description: "Error initialising k8s client", BackendError: err.Error()}
 	}
 
-	return InitUnmanagedSecretProvider(logger, *k8sClient, optionalArgs...)
+	usp, err := initUnmanagedSecretProvider(logger, kc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.secretWatcher {
+		usp.startSecretWatcher()
+	}
+	if opts.configWatcher {
+		usp.endpoints.startWatcher(usp.k8sClient, usp.logger, usp.subscribers.publishEndpointChange, usp.reloadConfig)
+	}
+	if opts.tokenRefreshFraction > 0 {
+		usp.startTokenRefresher(opts.tokenRefreshFraction)
+	}
+	return usp, nil
 }
 
-// initUnmanagedSecretProvider ...
+// InitUnmanagedSecretProvider ...
 func InitUnmanagedSecretProvider(logger *zap.Logger, kc k8s_utils.KubernetesClient, optionalArgs ...map[string]string) (*UnmanagedSecretProvider, error) {
-	authenticator, authType, err := auth.NewAuthenticator(logger, kc, optionalArgs...)
+	opts, err := newProviderOptions(optionsFromArgs(optionalArgs...)...)
 	if err != nil {
-		logger.Error("Error initializing unmanaged secret provider", zap.Error(err))
+		logger.Error("Error seen while validating arguments", zap.Error(err), zap.Any("Provided arguments", optionalArgs))
 		return nil, err
 	}
+	return initUnmanagedSecretProvider(logger, kc, opts)
+}
 
-	if authenticator.IsSecretEncrypted() {
-		logger.Error("Secret is encrypted, decryption is only supported by sidecar container")
-		return nil, utils.Error{Description: localutils.ErrDecryptionNotSupported}
-	}
-
-	// Checking if the secret(api key) needs to be decoded
-	if authType == utils.DEFAULT && os.Getenv("IS_SATELLITE") == "True" {
-		logger.Info("Decoding apiKey since it's a satellite cluster")
-		decodedSecret, err := base64.StdEncoding.DecodeString(authenticator.GetSecret())
-		if err != nil {
-			logger.Error("Error decoding the secret", zap.Error(err))
-			return nil, err
-		}
-		// In the decoded secret, newline could be present, trimming the same to extract a valid api key.
-		authenticator.SetSecret(strings.TrimSuffix(string(decodedSecret), "\n"))
+// initUnmanagedSecretProvider ...
+func initUnmanagedSecretProvider(logger *zap.Logger, kc k8s_utils.KubernetesClient, opts *providerOptions) (*UnmanagedSecretProvider, error) {
+	authArgs := opts.authArgs()
+	authenticator, authType, err := newAuthenticator(logger, kc, authArgs...)
+	if err != nil {
+		logger.Error("Error initializing unmanaged secret provider", zap.Error(err))
+		return nil, err
 	}
 
 	usp := new(UnmanagedSecretProvider)
 	usp.authenticator = authenticator
 	usp.logger = logger
 	usp.authType = authType
+	usp.authArgs = authArgs
 	usp.k8sClient = kc
+	usp.endpoints = newEndpointRegistry(opts.endpoints, opts.regionTemplates, opts.endpointValidation, opts.endpointFallback)
+	usp.tokenExpiryDiff = getTokenExpiryDiff(logger, opts)
+	usp.secretCache = newSecretCache(getSecretCacheLimit(logger, opts))
 
-	err = usp.initEndpointsUsingCloudConf()
+	ctx := context.Background()
+	err = usp.initEndpointsUsingCloudConf(ctx)
 	// If token exchange URL is also initialised using cloud conf, return.
 	if usp.tokenExchangeURL != "" {
 		logger.Info("Initialized unmanaged secret provider")
 		return usp, nil
 	}
 
-	cc, _ := config.GetClusterInfo(kc, logger)
+	cc, _ := getClusterInfo(ctx, logger, kc)
 
-	var providerName string
-	if len(optionalArgs) == 1 {
-		providerName, _ = optionalArgs[0][ProviderType]
-	}
+	providerName := opts.providerType
 	if providerName == "" {
 		providerName = utils.VPC
 	}
-	err = usp.initEndpointsUsingStorageSecretStore(cc, providerName)
+	err = usp.initEndpointsUsingStorageSecretStore(ctx, cc, providerName)
 	if usp.tokenExchangeURL != "" {
 		logger.Info("Initialized unmanaged secret provider")
 		return usp, nil
@@ -119,25 +179,152 @@ func InitUnmanagedSecretProvider(logger *zap.Logger, kc k8s_utils.KubernetesClie
 	return usp, nil
 }
 
+// newAuthenticator reads the secret and initialises the authenticator, ibm-cloud-credentials is read if present, else storage-secret-store.
+func newAuthenticator(logger *zap.Logger, kc k8s_utils.KubernetesClient, authArgs ...map[string]string) (auth.Authenticator, string, error) {
+	authenticator, authType, err := auth.NewAuthenticator(logger, kc, authArgs...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if authenticator.IsSecretEncrypted() {
+		logger.Error("Secret is encrypted, decryption is only supported by sidecar container")
+		return nil, "", utils.Error{Description: localutils.ErrDecryptionNotSupported}
+	}
+
+	// Checking if the secret(api key) needs to be decoded
+	if authType == utils.DEFAULT && os.Getenv("IS_SATELLITE") == "True" {
+		logger.Info("Decoding apiKey since it's a satellite cluster")
+		decodedSecret, err := base64.StdEncoding.DecodeString(authenticator.GetSecret())
+		if err != nil {
+			logger.Error("Error decoding the secret", zap.Error(err))
+			return nil, "", err
+		}
+		// In the decoded secret, newline could be present, trimming the same to extract a valid api key.
+		authenticator.SetSecret(strings.TrimSuffix(string(decodedSecret), "\n"))
+	}
+	return authenticator, authType, nil
+}
+
 // GetDefaultIAMToken ...
 func (usp *UnmanagedSecretProvider) GetDefaultIAMToken(isFreshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
-	usp.logger.Info("In GetDefaultIAMToken()")
-	return usp.authenticator.GetToken(true)
+	return usp.GetDefaultIAMTokenContext(context.Background(), isFreshTokenRequired, reasonForCall...)
+}
+
+// GetDefaultIAMTokenContext is same as GetDefaultIAMToken, returns as soon as ctx is done.
+func (usp *UnmanagedSecretProvider) GetDefaultIAMTokenContext(ctx context.Context, isFreshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	usp.logger.Debug("In GetDefaultIAMToken()")
+	if !isFreshTokenRequired {
+		if token, tokenlifetime, ok := usp.defaultToken.get(usp.tokenExpiryDiff); ok {
+			usp.logger.Debug("Returning cached IAM token", zap.Uint64("token-life-time-in-seconds", tokenlifetime))
+			return token, tokenlifetime, nil
+		}
+	}
+
+	// The concurrent callers share a single fetch
+	return usp.tokenFlight.do(ctx, flightKey(defaultFlightKey, isFreshTokenRequired), func(context.Context) (string, uint64, error) {
+		return usp.fetchDefaultToken(isFreshTokenRequired)
+	})
+}
+
+// fetchDefaultToken fetches the token of the default secret from IAM and caches it. If a fresh token is not required,
+// and the token was fetched by a concurrent call while this one was waiting, the cached token is returned.
+// The lock is held by the fetch itself, so that a fetch abandoned on ctx being done is not run alongside the next one.
+func (usp *UnmanagedSecretProvider) fetchDefaultToken(isFreshTokenRequired bool) (string, uint64, error) {
+	usp.tokenMutex.Lock()
+	defer usp.tokenMutex.Unlock()
+
+	if !isFreshTokenRequired {
+		if token, tokenlifetime, ok := usp.defaultToken.get(usp.tokenExpiryDiff); ok {
+			return token, tokenlifetime, nil
+		}
+	}
+
+	token, tokenlifetime, err := usp.authenticator.GetToken(true)
+	if err != nil {
+		return token, tokenlifetime, err
+	}
+	usp.defaultToken.set(token, tokenlifetime)
+	return token, tokenlifetime, nil
+}
+
+// getSecretCacheLimit returns the secret cache limit provided in the options, else the one set in the environment.
+// If neither is provided or the environment holds an invalid value, the default is returned.
+func getSecretCacheLimit(logger *zap.Logger, opts *providerOptions) int {
+	if opts.secretCacheLimit > 0 {
+		return opts.secretCacheLimit
+	}
+
+	value := os.Getenv(SecretCacheLimitEnv)
+	if value == "" {
+		return DefaultSecretCacheLimit
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		logger.Warn("Invalid secret cache limit, using the default", zap.String("value", value), zap.Int("default", DefaultSecretCacheLimit))
+		return DefaultSecretCacheLimit
+	}
+	return limit
+}
+
+// Close stops the background token refresher and the secret watcher, if they are running.
+func (usp *UnmanagedSecretProvider) Close() error {
+	usp.stopSecretWatcher()
+	usp.stopTokenRefresher()
+	usp.endpoints.stopWatcher()
+	return nil
+}
+
+// getTokenExpiryDiff returns the token expiry diff provided in the options, else the one set in the environment.
+// If neither is provided or the environment holds an invalid value, the default is returned.
+func getTokenExpiryDiff(logger *zap.Logger, opts *providerOptions) time.Duration {
+	if opts.tokenExpiryDiff != nil {
+		return *opts.tokenExpiryDiff
+	}
+
+	value := os.Getenv(TokenExpiryDiffEnv)
+	if value == "" {
+		return DefaultTokenExpiryDiff
+	}
+
+	tokenExpiryDiff, err := time.ParseDuration(value)
+	if err != nil || tokenExpiryDiff < 0 {
+		logger.Warn("Invalid token expiry diff, using the default", zap.String("value", value), zap.Duration("default", DefaultTokenExpiryDiff))
+		return DefaultTokenExpiryDiff
+	}
+	return tokenExpiryDiff
 }
 
 // GetIAMToken ...
 func (usp *UnmanagedSecretProvider) GetIAMToken(secret string, isFreshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
-	usp.logger.Info("In GetIAMToken()")
-	var authenticator auth.Authenticator
-	switch usp.authType {
+	return usp.GetIAMTokenContext(context.Background(), secret, isFreshTokenRequired, reasonForCall...)
+}
+
+// GetIAMTokenContext is same as GetIAMToken, returns as soon as ctx is done.
+func (usp *UnmanagedSecretProvider) GetIAMTokenContext(ctx context.Context, secret string, isFreshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	usp.logger.Debug("In GetIAMToken()")
+	usp.authMutex.RLock()
+	authType := usp.authType
+	usp.authMutex.RUnlock()
+
+	var newAuthenticator func() auth.Authenticator
+	switch authType {
 	case utils.IAM, utils.DEFAULT:
-		authenticator = auth.NewIamAuthenticator(secret, usp.logger)
+		newAuthenticator = func() auth.Authenticator { return auth.NewIamAuthenticator(secret, usp.logger) }
 	case utils.PODIDENTITY:
-		authenticator = auth.NewComputeIdentityAuthenticator(secret, usp.logger)
+		newAuthenticator = func() auth.Authenticator { return auth.NewComputeIdentityAuthenticator(secret, usp.logger) }
+	default:
+		usp.logger.Error("Invalid auth type", zap.String("auth-type", authType))
+		return "", 0, utils.Error{Description: fmt.Sprintf(localutils.ErrInvalidAuthType, authType)}
 	}
 
-	authenticator.SetURL(usp.tokenExchangeURL, usp.providedTokenExchangeURL)
-	token, tokenlifetime, err := authenticator.GetToken(true)
+	entry := usp.secretCache.get(hashSecret(secret), authType, func() tokenFetcher {
+		authenticator := newAuthenticator()
+		authenticator.SetURL(usp.getTokenExchangeURL())
+		return authenticator
+	})
+
+	token, tokenlifetime, err := entry.getToken(ctx, isFreshTokenRequired, usp.tokenExpiryDiff)
 	if err != nil {
 		usp.logger.Error("Error fetching IAM token", zap.Error(err))
 		return token, tokenlifetime, err
@@ -145,91 +332,183 @@ func (usp *UnmanagedSecretProvider) GetIAMToken(secret string, isFreshTokenRequi
 	return token, tokenlifetime, nil
 }
 
-// GetRIAASEndpoint ...
-func (usp *UnmanagedSecretProvider) GetRIAASEndpoint(readConfig bool) (string, error) {
-	usp.logger.Info("In GetRIAASEndpoint()")
-	if !readConfig {
-		usp.logger.Info("Returning RIAAS endpoint", zap.String("Endpoint", usp.riaasEndpoint))
-		return usp.riaasEndpoint, nil
+// GetIAMTokenForCredential returns the token of the given credential, irrespective of the auth type of the default secret.
+func (usp *UnmanagedSecretProvider) GetIAMTokenForCredential(ctx context.Context, credential Credential, isFreshTokenRequired bool, reasonForCall ...string) (string, uint64, error) {
+	usp.logger.Debug("In GetIAMTokenForCredential()", zap.String("credential-type", string(credential.Type)))
+	if err := credential.validate(); err != nil {
+		return "", 0, err
 	}
 
-	endpoint, err := getEndpoint(localutils.RIAAS, usp.riaasEndpoint, usp.k8sClient, usp.logger)
+	entry := usp.secretCache.get(credential.cacheKey(), string(credential.Type), func() tokenFetcher {
+		tokenExchangeURL, providedTokenExchangeURL := usp.getTokenExchangeURL()
+		return credential.newAuthenticator(usp.logger, tokenExchangeURL, providedTokenExchangeURL)
+	})
+
+	token, tokenlifetime, err := entry.getToken(ctx, isFreshTokenRequired, usp.tokenExpiryDiff)
 	if err != nil {
-		return "", err
+		usp.logger.Error("Error fetching IAM token for the provided credential", zap.Error(err))
+		return token, tokenlifetime, err
 	}
+	return token, tokenlifetime, nil
+}
 
-	usp.riaasEndpoint = endpoint
-	return endpoint, nil
+// GetRIAASEndpoint ...
+func (usp *UnmanagedSecretProvider) GetRIAASEndpoint(readConfig bool) (string, error) {
+	return usp.GetRIAASEndpointContext(context.Background(), readConfig)
+}
+
+// GetRIAASEndpointContext is same as GetRIAASEndpoint, reading the config is bound to ctx.
+func (usp *UnmanagedSecretProvider) GetRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error) {
+	usp.logger.Debug("In GetRIAASEndpoint()")
+	return usp.GetEndpoint(ctx, localutils.RIAAS, readConfig)
 }
 
 // GetPrivateRIAASEndpoint ...
 func (usp *UnmanagedSecretProvider) GetPrivateRIAASEndpoint(readConfig bool) (string, error) {
-	usp.logger.Info("In GetPrivateRIAASEndpoint()")
-	if !readConfig {
-		usp.logger.Info("Returning private RIAAS endpoint", zap.String("Endpoint", usp.privateRIAASEndpoint))
-		return usp.privateRIAASEndpoint, nil
-	}
-
-	endpoint, err := getEndpoint(localutils.PrivateRIAAS, usp.privateRIAASEndpoint, usp.k8sClient, usp.logger)
-	if err != nil {
-		return "", err
-	}
+	return usp.GetPrivateRIAASEndpointContext(context.Background(), readConfig)
+}
 
-	usp.privateRIAASEndpoint = endpoint
-	return endpoint, nil
+// GetPrivateRIAASEndpointContext is same as GetPrivateRIAASEndpoint, reading the config is bound to ctx.
+func (usp *UnmanagedSecretProvider) GetPrivateRIAASEndpointContext(ctx context.Context, readConfig bool) (string, error) {
+	usp.logger.Debug("In GetPrivateRIAASEndpoint()")
+	return usp.GetEndpoint(ctx, localutils.PrivateRIAAS, readConfig)
 }
 
 // GetContainerAPIRoute ...
 func (usp *UnmanagedSecretProvider) GetContainerAPIRoute(readConfig bool) (string, error) {
-	usp.logger.Info("In GetContainerAPIRoute()")
-	if !readConfig {
-		usp.logger.Info("Returning container api route", zap.String("Endpoint", usp.containerAPIRoute))
-		return usp.containerAPIRoute, nil
-	}
-
-	endpoint, err := getEndpoint(localutils.ContainerAPIRoute, usp.containerAPIRoute, usp.k8sClient, usp.logger)
-	if err != nil {
-		return "", err
-	}
+	return usp.GetContainerAPIRouteContext(context.Background(), readConfig)
+}
 
-	usp.containerAPIRoute = endpoint
-	return endpoint, nil
+// GetContainerAPIRouteContext is same as GetContainerAPIRoute, reading the config is bound to ctx.
+func (usp *UnmanagedSecretProvider) GetContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error) {
+	usp.logger.Debug("In GetContainerAPIRoute()")
+	return usp.GetEndpoint(ctx, localutils.ContainerAPIRoute, readConfig)
 }
 
 // GetPrivateContainerAPIRoute ...
 func (usp *UnmanagedSecretProvider) GetPrivateContainerAPIRoute(readConfig bool) (string, error) {
-	usp.logger.Info("In GetPrivateContainerAPIRoute()")
-	if !readConfig {
-		usp.logger.Info("Returning private container api route", zap.String("Endpoint", usp.privateContainerAPIRoute))
-		return usp.privateContainerAPIRoute, nil
-	}
+	return usp.GetPrivateContainerAPIRouteContext(context.Background(), readConfig)
+}
+
+// GetPrivateContainerAPIRouteContext is same as GetPrivateContainerAPIRoute, reading the config is bound to ctx.
+func (usp *UnmanagedSecretProvider) GetPrivateContainerAPIRouteContext(ctx context.Context, readConfig bool) (string, error) {
+	usp.logger.Debug("In GetPrivateContainerAPIRoute()")
+	return usp.GetEndpoint(ctx, localutils.PrivateContainerAPIRoute, readConfig)
+}
 
-	endpoint, err := getEndpoint(localutils.PrivateContainerAPIRoute, usp.privateContainerAPIRoute, usp.k8sClient, usp.logger)
+// GetEndpoint returns the endpoint of the given name, read from the config if refresh is true. The endpoints are
+// registered using WithEndpoint, along with the built in RIAAS, Private-RIAAS, Container-API-Route and Private-Container-API-Route.
+func (usp *UnmanagedSecretProvider) GetEndpoint(ctx context.Context, name string, refresh bool) (string, error) {
+	endpoint, previous, err := usp.endpoints.getEndpoint(ctx, name, refresh, usp.k8sClient, usp.logger)
 	if err != nil {
 		return "", err
 	}
 
-	usp.privateContainerAPIRoute = endpoint
+	usp.subscribers.publishEndpointChange(name, previous, endpoint)
 	return endpoint, nil
 }
 
+// ListEndpoints returns the names of the registered endpoints, in sorted order.
+func (usp *UnmanagedSecretProvider) ListEndpoints() []string {
+	return usp.endpoints.names()
+}
+
+// GetEndpointSource returns the config from which the endpoint of the given name was read, such as EndpointSourceDerived
+// if the endpoint was derived from the region.
+func (usp *UnmanagedSecretProvider) GetEndpointSource(name string) (EndpointSource, error) {
+	return usp.endpoints.getSource(name)
+}
+
+// DescribeEndpoints returns the registered endpoints along with the config, the key in it, and the time they were read.
+func (usp *UnmanagedSecretProvider) DescribeEndpoints() []EndpointInfo {
+	return usp.endpoints.describe()
+}
+
 // GetResourceGroupID ...
 func (usp *UnmanagedSecretProvider) GetResourceGroupID() string {
+	usp.configMutex.RLock()
+	defer usp.configMutex.RUnlock()
 	return usp.resourceGroupID
 }
 
+// GetRegion ...
+func (usp *UnmanagedSecretProvider) GetRegion() string {
+	usp.configMutex.RLock()
+	defer usp.configMutex.RUnlock()
+	return usp.region
+}
+
+// GetTokenExchangeURL returns the token exchange URL read from cloud-conf or storage-secret-store, or framed using the cluster info.
+func (usp *UnmanagedSecretProvider) GetTokenExchangeURL() string {
+	tokenExchangeURL, _ := usp.getTokenExchangeURL()
+	return tokenExchangeURL
+}
+
+// IsPrivateTokenExchange ...
+func (usp *UnmanagedSecretProvider) IsPrivateTokenExchange() bool {
+	return isPrivateEndpoint(usp.GetTokenExchangeURL())
+}
+
+// getTokenExchangeURL returns the token exchange URL and whether it was provided in the config.
+func (usp *UnmanagedSecretProvider) getTokenExchangeURL() (string, bool) {
+	usp.configMutex.RLock()
+	defer usp.configMutex.RUnlock()
+	return usp.tokenExchangeURL, usp.providedTokenExchangeURL
+}
+
+// reloadConfig replaces the region, resource group ID and token exchange URL with the ones read by the config watcher.
+// The ones which cannot be read are kept. If the token exchange URL changes, it is set in the authenticators.
+func (usp *UnmanagedSecretProvider) reloadConfig(reader configReader) {
+	region, resourceGroupID, tokenExchangeURL, err := readConfig(context.Background(), reader)
+	if err != nil {
+		usp.logger.Warn("Unable to read config, keeping the previous region, resource group ID and token exchange URL", zap.Error(err))
+		return
+	}
+
+	usp.configMutex.Lock()
+	if region != "" {
+		usp.region = region
+	}
+	if resourceGroupID != "" {
+		usp.resourceGroupID = resourceGroupID
+	}
+	tokenExchangeURLChanged := tokenExchangeURL != "" && tokenExchangeURL != usp.tokenExchangeURL
+	if tokenExchangeURLChanged {
+		usp.tokenExchangeURL, usp.providedTokenExchangeURL = tokenExchangeURL, true
+	}
+	usp.configMutex.Unlock()
+
+	if !tokenExchangeURLChanged {
+		return
+	}
+	usp.logger.Info("Token exchange URL changed", zap.String("url", tokenExchangeURL))
+	usp.tokenMutex.Lock()
+	usp.authMutex.Lock()
+	usp.authenticator.SetURL(tokenExchangeURL, true)
+	usp.authMutex.Unlock()
+	usp.tokenMutex.Unlock()
+
+	// The authenticators of the other secrets are created again, using the token exchange URL
+	usp.secretCache.clear()
+}
+
+// Subscribe registers the given callback for the events, which is called until the returned function is called.
+// Events related to the secret are emitted only if the secret watcher is started using WithSecretWatcher.
+func (usp *UnmanagedSecretProvider) Subscribe(callback func(Event)) func() {
+	return usp.subscribers.subscribe(callback)
+}
+
 // initEndpointsUsingCloudConf ...
-func (usp *UnmanagedSecretProvider) initEndpointsUsingCloudConf() error {
-	cloudConf, err := config.GetCloudConf(usp.logger, usp.k8sClient)
+func (usp *UnmanagedSecretProvider) initEndpointsUsingCloudConf(ctx context.Context) error {
+	cloudConf, err := getCloudConf(ctx, usp.k8sClient)
 	if err != nil {
 		return err
 	}
 
 	usp.region = cloudConf.Region
-	usp.containerAPIRoute = cloudConf.ContainerAPIRoute
-	usp.privateContainerAPIRoute = cloudConf.PrivateContainerAPIRoute
-	usp.riaasEndpoint = cloudConf.RiaasEndpoint
-	usp.privateRIAASEndpoint = cloudConf.PrivateRIAASEndpoint
+	if fields, err := getCloudConfFields(ctx, usp.k8sClient); err == nil {
+		usp.endpoints.setFromCloudConf(ctx, fields, usp.k8sClient, usp.logger)
+	}
 	usp.resourceGroupID = cloudConf.ResourceGroupID
 	usp.logger.Info("Initialised endpoints using cloud-conf")
 	if cloudConf.TokenExchangeURL != "" {
@@ -245,24 +524,14 @@ func (usp *UnmanagedSecretProvider) initEndpointsUsingCloudConf() error {
 }
 
 // initEndpointsUsingStorageSecretStore ...
-func (usp *UnmanagedSecretProvider) initEndpointsUsingStorageSecretStore(cc config.ClusterConfig, providerType string) error {
-	data, err := k8s_utils.GetSecretData(usp.k8sClient, utils.STORAGE_SECRET_STORE_SECRET, utils.SECRET_STORE_FILE)
-	var conf *config.Config
-	if err != nil {
-		usp.logger.Warn("Error fetching storage-secret-store data", zap.Error(err))
-		return err
-	}
-
-	conf, err = config.ParseConfig(usp.logger, data)
+func (usp *UnmanagedSecretProvider) initEndpointsUsingStorageSecretStore(ctx context.Context, cc config.ClusterConfig, providerType string) error {
+	conf, fields, err := getSecretStore(ctx, usp.logger, usp.k8sClient)
 	if err != nil {
-		usp.logger.Warn("Error parsing storage-secret-store data", zap.Error(err))
+		usp.logger.Warn("Error reading storage-secret-store data", zap.Error(err))
 		return err
 	}
 
-	usp.containerAPIRoute = conf.Bluemix.APIEndpointURL
-	usp.privateContainerAPIRoute = conf.Bluemix.PrivateAPIRoute
-	usp.riaasEndpoint = conf.VPC.G2EndpointURL
-	usp.privateRIAASEndpoint = conf.VPC.G2EndpointPrivateURL
+	usp.endpoints.setFromSecretStore(fields, usp.logger)
 	usp.resourceGroupID = conf.VPC.G2ResourceGroupID
 	usp.logger.Info("Fetched endpoints from storage-secret-store")
 
@@ -0,0 +1,226 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package sidecar is a reference implementation of the secret sidecar, which serves the secretprovider grpc service
+// on a unix socket by delegating to the unmanaged secret provider.
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	secret_provider "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	localutils "github.com/IBM/secret-common-lib/pkg/utils"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	sp "github.com/IBM/secret-utils-lib/secretprovider"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Config holds the configuration of the sidecar.
+type Config struct {
+	// TokenExpiryDiff is the minimum validity of the tokens returned, a fresh token is fetched if the cached one expires sooner.
+	TokenExpiryDiff time.Duration
+	// SecretCacheLimit is the number of secrets, other than the default secret, whose tokens are cached.
+	SecretCacheLimit int
+}
+
+// ConfigFromEnv reads the configuration from TOKEN_EXPIRY_DIFF and SECRET_CACHE_LIMIT, the defaults are 5m and 10, same
+// as the unmanaged secret provider.
+func ConfigFromEnv() (Config, error) {
+	conf := Config{TokenExpiryDiff: secret_provider.DefaultTokenExpiryDiff, SecretCacheLimit: secret_provider.DefaultSecretCacheLimit}
+	if value := os.Getenv(secret_provider.TokenExpiryDiffEnv); value != "" {
+		tokenExpiryDiff, err := time.ParseDuration(value)
+		if err != nil || tokenExpiryDiff < 0 {
+			return conf, utils.Error{Description: localutils.ErrInvalidTokenExpiryDiff, BackendError: value}
+		}
+		conf.TokenExpiryDiff = tokenExpiryDiff
+	}
+
+	if value := os.Getenv(secret_provider.SecretCacheLimitEnv); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return conf, utils.Error{Description: localutils.ErrInvalidSecretCacheLimit, BackendError: value}
+		}
+		conf.SecretCacheLimit = limit
+	}
+	return conf, nil
+}
+
+// Server serves the secretprovider grpc service and the grpc health service. The tokens of the default secret and of
+// up to SecretCacheLimit other secrets are cached by the unmanaged secret provider, the least recently used secret is
+// removed from the cache first.
+type Server struct {
+	sp.UnimplementedSecretProviderServer
+
+	logger     *zap.Logger
+	k8sClient  k8s_utils.KubernetesClient
+	conf       Config
+	grpcServer *grpc.Server
+
+	// providerMutex guards the unmanaged secret provider, which is initialised on the first call.
+	providerMutex sync.Mutex
+	provider      secret_provider.ContextSecretProvider
+	providerType  string
+
+	// newProvider initialises the unmanaged secret provider using the given options.
+	newProvider func(opts ...secret_provider.Option) (secret_provider.ContextSecretProvider, error)
+}
+
+// NewServer ...
+func NewServer(logger *zap.Logger, k8sClient k8s_utils.KubernetesClient, conf Config) (*Server, error) {
+	if conf.TokenExpiryDiff < 0 {
+		return nil, utils.Error{Description: localutils.ErrInvalidTokenExpiryDiff, BackendError: conf.TokenExpiryDiff.String()}
+	}
+	if conf.SecretCacheLimit <= 0 {
+		return nil, utils.Error{Description: localutils.ErrInvalidSecretCacheLimit, BackendError: strconv.Itoa(conf.SecretCacheLimit)}
+	}
+
+	s := &Server{logger: logger, k8sClient: k8sClient, conf: conf, grpcServer: grpc.NewServer()}
+	s.newProvider = s.newUnmanagedProvider
+	sp.RegisterSecretProviderServer(s.grpcServer, s)
+	healthpb.RegisterHealthServer(s.grpcServer, health.NewServer())
+	return s, nil
+}
+
+// ListenAndServe listens on the given unix socket and serves the calls until Stop is called. A stale socket is removed,
+// and the socket is removed once serving stops.
+func (s *Server) ListenAndServe(endpoint string) error {
+	if err := os.Remove(endpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", endpoint)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Serving secret provider", zap.String("endpoint", endpoint))
+	err = s.grpcServer.Serve(listener)
+	if removeErr := os.Remove(endpoint); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+		s.logger.Warn("Unable to remove the socket", zap.String("endpoint", endpoint), zap.Error(removeErr))
+	}
+	return err
+}
+
+// Stop stops accepting new calls and waits for the ongoing calls to complete.
+func (s *Server) Stop() {
+	s.grpcServer.GracefulStop()
+}
+
+// NewSecretProvider initialises the unmanaged secret provider with the given provider type.
+func (s *Server) NewSecretProvider(ctx context.Context, req *sp.InitRequest) (*sp.Empty, error) {
+	s.logger.Info("Initializing secret provider", zap.String("providerType", req.GetProviderType()))
+	if _, err := s.getProvider(req.GetProviderType()); err != nil {
+		return nil, toStatus(err)
+	}
+	return &sp.Empty{}, nil
+}
+
+// GetDefaultIAMToken returns the token of the default secret, from the cache if it is valid for longer than TokenExpiryDiff.
+func (s *Server) GetDefaultIAMToken(ctx context.Context, req *sp.Request) (*sp.IAMToken, error) {
+	s.logger.Debug("Fetching IAM token for default secret", zap.String("reasonForCall", req.GetReasonForCall()), zap.Bool("isFreshTokenRequired", req.GetIsFreshTokenRequired()))
+	provider, err := s.getProvider("")
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	token, tokenlifetime, err := provider.GetDefaultIAMTokenContext(ctx, req.GetIsFreshTokenRequired(), req.GetReasonForCall())
+	if err != nil {
+		s.logger.Error("Error fetching IAM token for default secret", zap.Error(err))
+		return nil, toStatus(err)
+	}
+	return &sp.IAMToken{Iamtoken: token, Tokenlifetime: tokenlifetime}, nil
+}
+
+// GetIAMToken returns the token of the given secret, from the cache if it is valid for longer than TokenExpiryDiff.
+func (s *Server) GetIAMToken(ctx context.Context, req *sp.Request) (*sp.IAMToken, error) {
+	s.logger.Debug("Fetching IAM token for the provided secret", zap.String("reasonForCall", req.GetReasonForCall()), zap.Bool("isFreshTokenRequired", req.GetIsFreshTokenRequired()))
+	provider, err := s.getProvider("")
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	token, tokenlifetime, err := provider.GetIAMTokenContext(ctx, req.GetSecret(), req.GetIsFreshTokenRequired(), req.GetReasonForCall())
+	if err != nil {
+		s.logger.Error("Error fetching IAM token for the provided secret", zap.Error(err))
+		return nil, toStatus(err)
+	}
+	return &sp.IAMToken{Iamtoken: token, Tokenlifetime: tokenlifetime}, nil
+}
+
+// getProvider returns the unmanaged secret provider, initialising it if it is not initialised yet, or if a different provider type is given.
+// The replaced secret provider is closed, the calls in flight using it are not affected.
+func (s *Server) getProvider(providerType string) (secret_provider.ContextSecretProvider, error) {
+	s.providerMutex.Lock()
+	defer s.providerMutex.Unlock()
+
+	if s.provider != nil && (providerType == "" || providerType == s.providerType) {
+		return s.provider, nil
+	}
+
+	opts := []secret_provider.Option{
+		secret_provider.WithMode(secret_provider.Unmanaged),
+		secret_provider.WithLogger(s.logger),
+		secret_provider.WithTokenExpiryDiff(s.conf.TokenExpiryDiff),
+		secret_provider.WithSecretCacheLimit(s.conf.SecretCacheLimit),
+	}
+	if providerType != "" {
+		opts = append(opts, secret_provider.WithProviderType(providerType))
+	}
+	provider, err := s.newProvider(opts...)
+	if err != nil {
+		s.logger.Error("Error initializing secret provider", zap.Error(err))
+		return nil, err
+	}
+
+	if closer, ok := s.provider.(interface{ Close() error }); ok {
+		if err := closer.Close(); err != nil {
+			s.logger.Warn("Error closing the previous secret provider", zap.Error(err))
+		}
+	}
+	s.provider = provider
+	s.providerType = providerType
+	s.logger.Info("Initialized secret provider", zap.String("providerType", providerType))
+	return s.provider, nil
+}
+
+// newUnmanagedProvider ...
+func (s *Server) newUnmanagedProvider(opts ...secret_provider.Option) (secret_provider.ContextSecretProvider, error) {
+	provider, err := secret_provider.NewSecretProviderWithOptions(&s.k8sClient, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return provider.(secret_provider.ContextSecretProvider), nil
+}
+
+// toStatus converts the given error to a grpc status error, so that the client can tell it apart from being unable to reach the sidecar.
+func toStatus(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return status.Error(codes.Internal, err.Error())
+}
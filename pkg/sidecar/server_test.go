@@ -0,0 +1,231 @@
+/**
+ * Copyright 2022 IBM Corp.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	secret_provider "github.com/IBM/secret-common-lib/pkg/secret_provider"
+	"github.com/IBM/secret-utils-lib/pkg/k8s_utils"
+	"github.com/IBM/secret-utils-lib/pkg/utils"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// closeRecorder records whether the secret provider was closed.
+type closeRecorder struct {
+	secret_provider.ContextSecretProvider
+	closed bool
+}
+
+// Close ...
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// newTestServer returns a server using a fake k8s client, which holds ibm-cloud-credentials and cloud-conf. The
+// secret providers initialised by the server are recorded.
+func newTestServer(t *testing.T) (*Server, *[]*closeRecorder) {
+	t.Helper()
+	kc, err := k8s_utils.FakeGetk8sClientSet()
+	if err != nil {
+		t.Fatalf("Unable to create fake k8s client: %v", err)
+	}
+	if err := k8s_utils.FakeCreateSecret(kc, utils.IAM, "../../test-fixtures/secrets/ibm-cloud-credentials/iam-cloud-provider.env"); err != nil {
+		t.Fatalf("Unable to create ibm-cloud-credentials: %v", err)
+	}
+	cloudConf := `{"region": "us-south", "riaas_endpoint": "https://us-south.iaas.cloud.ibm.com", "token_exchange_url": "https://iam.cloud.ibm.com"}`
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cloud-conf", Namespace: kc.Namespace}, Data: map[string]string{"cloud-conf.json": cloudConf}}
+	if _, err := kc.Clientset.CoreV1().ConfigMaps(kc.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Unable to create cloud-conf: %v", err)
+	}
+
+	s, err := NewServer(zap.NewNop(), kc, Config{TokenExpiryDiff: secret_provider.DefaultTokenExpiryDiff, SecretCacheLimit: secret_provider.DefaultSecretCacheLimit})
+	if err != nil {
+		t.Fatalf("Unable to create server: %v", err)
+	}
+
+	var providers []*closeRecorder
+	newProvider := s.newProvider
+	s.newProvider = func(opts ...secret_provider.Option) (secret_provider.ContextSecretProvider, error) {
+		provider, err := newProvider(opts...)
+		if err != nil {
+			return nil, err
+		}
+		recorder := &closeRecorder{ContextSecretProvider: provider}
+		providers = append(providers, recorder)
+		return recorder, nil
+	}
+	return s, &providers
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	testCases := []struct {
+		name             string
+		tokenExpiryDiff  string
+		secretCacheLimit string
+		expected         Config
+		expectErr        bool
+	}{
+		{name: "defaults", expected: Config{TokenExpiryDiff: 5 * time.Minute, SecretCacheLimit: 10}},
+		{name: "token expiry diff", tokenExpiryDiff: "20m", expected: Config{TokenExpiryDiff: 20 * time.Minute, SecretCacheLimit: 10}},
+		{name: "secret cache limit", secretCacheLimit: "50", expected: Config{TokenExpiryDiff: 5 * time.Minute, SecretCacheLimit: 50}},
+		{name: "zero token expiry diff", tokenExpiryDiff: "0s", expected: Config{TokenExpiryDiff: 0, SecretCacheLimit: 10}},
+		{name: "invalid token expiry diff", tokenExpiryDiff: "twenty", expectErr: true},
+		{name: "negative token expiry diff", tokenExpiryDiff: "-1m", expectErr: true},
+		{name: "invalid secret cache limit", secretCacheLimit: "ten", expectErr: true},
+		{name: "zero secret cache limit", secretCacheLimit: "0", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(secret_provider.TokenExpiryDiffEnv, tc.tokenExpiryDiff)
+			t.Setenv(secret_provider.SecretCacheLimitEnv, tc.secretCacheLimit)
+
+			conf, err := ConfigFromEnv()
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", conf)
+				}
+				return
+			}
+			if err != nil || conf != tc.expected {
+				t.Errorf("Expected %+v, got %+v, %v", tc.expected, conf, err)
+			}
+		})
+	}
+}
+
+func TestGetProvider(t *testing.T) {
+	type call struct {
+		providerType string
+		expectNew    bool
+	}
+	testCases := []struct {
+		name  string
+		calls []call
+	}{
+		{
+			name:  "initialised on the first call",
+			calls: []call{{providerType: "", expectNew: true}, {providerType: ""}, {providerType: ""}},
+		},
+		{
+			name:  "same provider type is reused",
+			calls: []call{{providerType: utils.VPC, expectNew: true}, {providerType: utils.VPC}, {providerType: ""}},
+		},
+		{
+			name:  "switching provider type",
+			calls: []call{{providerType: "", expectNew: true}, {providerType: utils.VPC, expectNew: true}, {providerType: utils.Bluemix, expectNew: true}, {providerType: ""}, {providerType: utils.VPC, expectNew: true}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, providers := newTestServer(t)
+			var current secret_provider.ContextSecretProvider
+			for i, c := range tc.calls {
+				created := len(*providers)
+				provider, err := s.getProvider(c.providerType)
+				if err != nil {
+					t.Fatalf("Call %d: unable to get provider: %v", i, err)
+				}
+				if isNew := len(*providers) > created; isNew != c.expectNew {
+					t.Fatalf("Call %d with %q: expected a new provider %v, got %v", i, c.providerType, c.expectNew, isNew)
+				}
+				if !c.expectNew && provider != current {
+					t.Fatalf("Call %d with %q: expected the current provider to be returned", i, c.providerType)
+				}
+				current = provider
+			}
+
+			// Every replaced provider is closed, the current one is not
+			for i, p := range *providers {
+				if expectClosed := i < len(*providers)-1; p.closed != expectClosed {
+					t.Errorf("Provider %d: expected closed %v, got %v", i, expectClosed, p.closed)
+				}
+			}
+		})
+	}
+}
+
+func TestGetProviderError(t *testing.T) {
+	s, providers := newTestServer(t)
+	previous, err := s.getProvider(utils.VPC)
+	if err != nil {
+		t.Fatalf("Unable to get provider: %v", err)
+	}
+
+	// The current provider is kept if the new one cannot be initialised
+	s.newProvider = func(opts ...secret_provider.Option) (secret_provider.ContextSecretProvider, error) {
+		return nil, errors.New("unable to initialise")
+	}
+	if _, err := s.getProvider(utils.Bluemix); err == nil {
+		t.Fatal("Expected an error initialising the provider")
+	}
+	if (*providers)[0].closed {
+		t.Error("Expected the current provider not to be closed")
+	}
+	if provider, err := s.getProvider(""); err != nil || provider != previous {
+		t.Errorf("Expected the current provider to be kept, got %v", err)
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	s, _ := newTestServer(t)
+	endpoint := filepath.Join(t.TempDir(), "provider.sock")
+	// A stale socket is removed
+	if err := os.WriteFile(endpoint, nil, 0600); err != nil {
+		t.Fatalf("Unable to create stale socket: %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe(endpoint) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", endpoint)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unable to connect to %s: %v", endpoint, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Stop()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected serving to stop without an error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected serving to stop")
+	}
+	if _, err := os.Stat(endpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the socket to be removed, got %v", err)
+	}
+}
@@ -40,4 +40,64 @@ const (
 
 	// ErrEmptySecretKeyProvided ...
 	ErrEmptySecretKeyProvided = "Provided secret key is empty"
+
+	// ErrNilLogger ...
+	ErrNilLogger = "Provided logger is nil"
+
+	// ErrEmptySidecarEndpoint ...
+	ErrEmptySidecarEndpoint = "Provided sidecar endpoint is empty"
+
+	// ErrEmptyNamespace ...
+	ErrEmptyNamespace = "Provided namespace is empty"
+
+	// ErrInvalidMode ...
+	ErrInvalidMode = "Invalid mode given, expected values are auto, managed, unmanaged"
+
+	// ErrSidecarConnectionClosed ...
+	ErrSidecarConnectionClosed = "Connection to sidecar is closed"
+
+	// ErrSidecarUnreachable ...
+	ErrSidecarUnreachable = "Unable to reach secret sidecar"
+
+	// ErrFetchingTokenFromSidecar ...
+	ErrFetchingTokenFromSidecar = "Secret sidecar failed to fetch IAM token"
+
+	// ErrInvalidSidecarRetry ...
+	ErrInvalidSidecarRetry = "Invalid sidecar retry configuration, retries and backoff must not be negative"
+
+	// ErrSidecarUnhealthy ...
+	ErrSidecarUnhealthy = "Secret sidecar is not serving"
+
+	// ErrInvalidHealthCheckInterval ...
+	ErrInvalidHealthCheckInterval = "Invalid health check interval, it must be positive"
+
+	// ErrSecretKeyUnsupported ...
+	ErrSecretKeyUnsupported = "Secret key is not supported by managed secret provider"
+
+	// ErrInvalidTokenExpiryDiff ...
+	ErrInvalidTokenExpiryDiff = "Invalid token expiry difference, it must be a non negative duration such as 20m"
+
+	// ErrInvalidSecretCacheLimit ...
+	ErrInvalidSecretCacheLimit = "Invalid secret cache limit, it must be a positive number"
+
+	// ErrInvalidTokenRefreshFraction ...
+	ErrInvalidTokenRefreshFraction = "Invalid token refresh fraction, it must be between 0 and 1"
+
+	// ErrInvalidAuthType ...
+	ErrInvalidAuthType = "Invalid auth type %s, expected values are iam, pod-identity"
+
+	// ErrInvalidCredential ...
+	ErrInvalidCredential = "Invalid credential provided"
+
+	// ErrInvalidEndpointDefinition ...
+	ErrInvalidEndpointDefinition = "Invalid endpoint definition provided"
+
+	// ErrUnknownEndpoint ...
+	ErrUnknownEndpoint = "%s endpoint is not registered"
+
+	// ErrInvalidEndpoint ...
+	ErrInvalidEndpoint = "Invalid endpoint %s"
+
+	// ErrUnreachableEndpoint ...
+	ErrUnreachableEndpoint = "Endpoint %s is not reachable"
 )